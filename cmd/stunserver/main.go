@@ -0,0 +1,195 @@
+//STUN服务器命令,SIGHUP重新加载配置文件,SIGINT/SIGTERM优雅关闭
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+//优雅关闭的最长等待时间
+const shutdownTimeout = time.Second * 10
+
+//配置文件,JSON格式,SIGHUP时重新读取;监听地址和证书只在启动时读取
+type config struct {
+	Software string `json:"software"`
+	Classic  bool   `json:"classic"`
+
+	//realm不为空时使用长期凭证,users不为空时要求认证
+	Realm string `json:"realm"`
+	Users string `json:"users"` // 凭证文件,每行"用户名:密码"
+	//nonce签名密钥,十六进制,多个实例配置相同密钥;
+	//为空时使用启动时生成的随机密钥,重新加载配置不会使nonce失效,但重启后失效
+	Secret string `json:"secret"`
+
+	RateLimit        float64  `json:"rate_limit"`
+	RateBurst        int      `json:"rate_burst"`
+	MaxAmplification float64  `json:"max_amplification"`
+	Allow            []string `json:"allow"`
+	Deny             []string `json:"deny"`
+	IdleTimeout      string   `json:"idle_timeout"`
+	HandshakeTimeout string   `json:"handshake_timeout"`
+	MaxConnections   int      `json:"max_connections"`
+}
+
+//读取配置文件,配置中没有secret时使用generated
+func loadOptions(path string, generated []byte) (server.Options, error) {
+	var (
+		c config
+		o server.Options
+	)
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return o, err
+		}
+		if err = json.Unmarshal(b, &c); err != nil {
+			return o, err
+		}
+	}
+	o.Software = c.Software
+	o.Classic = c.Classic
+	o.RateLimit = c.RateLimit
+	o.RateBurst = c.RateBurst
+	o.MaxAmplification = c.MaxAmplification
+	o.MaxConnections = c.MaxConnections
+	var err error
+	if o.Allow, err = server.ParseCIDRs(c.Allow...); err != nil {
+		return o, err
+	}
+	if o.Deny, err = server.ParseCIDRs(c.Deny...); err != nil {
+		return o, err
+	}
+	if c.IdleTimeout != "" {
+		if o.IdleTimeout, err = time.ParseDuration(c.IdleTimeout); err != nil {
+			return o, err
+		}
+	}
+	if c.HandshakeTimeout != "" {
+		if o.HandshakeTimeout, err = time.ParseDuration(c.HandshakeTimeout); err != nil {
+			return o, err
+		}
+	}
+
+	mux := server.NewServeMux()
+	mux.Handle(stun.BindingRequest, server.Binding)
+	middleware := []server.Middleware{server.RecoverWithLogger(log.Printf)}
+	if c.Users != "" {
+		creds, err := server.LoadCredentials(c.Users)
+		if err != nil {
+			return o, err
+		}
+		secret, err := hex.DecodeString(c.Secret)
+		if err != nil {
+			return o, err
+		}
+		if len(secret) == 0 {
+			log.Printf("warning: no secret configured, nonces are signed with a generated secret and become invalid after restart")
+			secret = generated
+		}
+		middleware = append(middleware, server.Authenticate(server.AuthOptions{
+			Realm:       c.Realm,
+			Credentials: creds,
+			Secret:      secret,
+		}))
+	}
+	o.Handler = server.Chain(mux, middleware...)
+	return o, nil
+}
+
+func main() {
+	var (
+		configPath = flag.String("config", "", "JSON config file, reloaded on SIGHUP")
+		udpAddr    = flag.String("udp", ":3478", "UDP listen address, empty to disable")
+		tcpAddr    = flag.String("tcp", ":3478", "TCP listen address, empty to disable")
+		tlsAddr    = flag.String("tls", "", "TLS listen address, e.g. :5349")
+		certFile   = flag.String("cert", "", "TLS certificate file")
+		keyFile    = flag.String("key", "", "TLS key file")
+	)
+	flag.Parse()
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	o, err := loadOptions(*configPath, secret)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	s := server.New(o)
+
+	errs := make(chan error, 3)
+	if *udpAddr != "" {
+		conn, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving udp on %s", conn.LocalAddr())
+		go func() { errs <- s.Serve(conn) }()
+	}
+	if *tcpAddr != "" {
+		ln, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving tcp on %s", ln.Addr())
+		go func() { errs <- s.ServeListener(ln) }()
+	}
+	if *tlsAddr != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", *tlsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving tls on %s", ln.Addr())
+		go func() { errs <- s.ServeTLS(ln, &tls.Config{Certificates: []tls.Certificate{cert}}) }()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err = <-errs:
+			if err != server.ErrServerClosed {
+				log.Printf("serve: %v", err)
+			}
+			s.Close()
+			os.Exit(1)
+		case v := <-sig:
+			if v == syscall.SIGHUP {
+				o, err := loadOptions(*configPath, secret)
+				if err != nil {
+					log.Printf("reload config: %v", err)
+					continue
+				}
+				s.Reload(o)
+				log.Printf("config reloaded")
+				continue
+			}
+			log.Printf("%v, shutting down", v)
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			err = s.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("shutdown: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
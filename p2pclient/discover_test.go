@@ -0,0 +1,81 @@
+package p2pclient_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/p2pclient"
+	"github.com/cocobao/cocostun/stun"
+)
+
+//模拟端口受限NAT后的RFC 3489服务器:只回复不带CHANGE-REQUEST的请求,
+//映射地址与本地地址不同,收到Test I时把收到请求的地址发到got
+func serveRestricted(conn net.PacketConn, changed *net.UDPAddr, got chan<- string) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if req.Decode() != nil {
+			continue
+		}
+		if _, ok := req.Attributes.Get(stun.AttrChangeRequest); ok {
+			continue
+		}
+		got <- conn.LocalAddr().String()
+		res, err := stun.Build(stun.NewTransactionIDSetter(req.TransactionID), stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 1234},
+			&stun.ChangedAddress{IP: changed.IP, Port: changed.Port},
+		)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(res.Raw, addr)
+	}
+}
+
+//第二次探测仍然从主服务器开始,而不是上次切换到的CHANGED-ADDRESS
+func TestDiscoverTwice(t *testing.T) {
+	primary := listenUDP(t)
+	defer primary.Close()
+	changed := listenUDP(t)
+	defer changed.Close()
+	got := make(chan string, 8)
+	go serveRestricted(primary, changed.LocalAddr().(*net.UDPAddr), got)
+	go serveRestricted(changed, changed.LocalAddr().(*net.UDPAddr), got)
+
+	cli, err := p2pclient.NewP2PClient(primary.LocalAddr().String(), "cocosp2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		select {
+		case addr := <-got:
+			return addr
+		case <-time.After(time.Second * 10):
+			t.Fatal("no Test I received")
+			return ""
+		}
+	}
+
+	done := make(chan struct{}, 2)
+	cli.Discover(func() { done <- struct{}{} })
+	if addr := next(); addr != primary.LocalAddr().String() {
+		t.Fatalf("first Test I to %s, want primary", addr)
+	}
+	if addr := next(); addr != changed.LocalAddr().String() {
+		t.Fatalf("second Test I to %s, want changed address", addr)
+	}
+	<-done
+	if nat := cli.GetNatType(); nat != p2pclient.NATPortRestricted.String() {
+		t.Fatalf("got %s, want %s", nat, p2pclient.NATPortRestricted)
+	}
+
+	cli.Discover(func() { done <- struct{}{} })
+	if addr := next(); addr != primary.LocalAddr().String() {
+		t.Fatalf("second Discover sent Test I to %s, want primary %s", addr, primary.LocalAddr())
+	}
+}
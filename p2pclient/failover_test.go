@@ -0,0 +1,75 @@
+package p2pclient_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/cocobao/cocostun/p2pclient"
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+type stubResolver map[string][]*net.SRV
+
+func (r stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	srvs, ok := r[name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, srvs, nil
+}
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func port(conn net.PacketConn) uint16 {
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+//第一个SRV目标不响应,超时后切换到第二个
+func TestClientFailover(t *testing.T) {
+	silent := listenUDP(t)
+	defer silent.Close()
+	conn := listenUDP(t)
+	s := server.New(server.Options{})
+	go s.Serve(conn)
+	defer s.Close()
+
+	r := stubResolver{
+		"example.org": {
+			{Target: "silent.example.org", Port: port(silent), Priority: 10},
+			{Target: "server.example.org", Port: port(conn), Priority: 20},
+		},
+	}
+	cli, err := p2pclient.NewP2PClientWithResolver("example.org", "cocosp2p", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan stun.AgentEvent, 1)
+	cli.TestI(func(e stun.AgentEvent) { done <- e })
+	if e := <-done; e.Error != stun.ErrTransactionTimeOut {
+		t.Fatalf("first target: got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+	}
+	cli.TestI(func(e stun.AgentEvent) { done <- e })
+	e := <-done
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if want := "127.0.0.1:" + strconv.Itoa(int(port(conn))); e.Remote.String() != want {
+		t.Errorf("response from %s, want %s", e.Remote, want)
+	}
+}
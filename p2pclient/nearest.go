@@ -0,0 +1,72 @@
+package p2pclient
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/log"
+)
+
+var errNoServerResponded = errors.New("no stun server responded")
+
+//同时向所有服务器发送绑定请求,返回RTT最小的服务器及其RTT
+func NearestServer(servers []string, timeout time.Duration) (string, time.Duration, error) {
+	var (
+		names []string
+		addrs []*net.UDPAddr
+	)
+	for _, s := range servers {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			log.Warnf("resolve %s fail, err:%v", s, err)
+			continue
+		}
+		names = append(names, s)
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return "", 0, errNoServerResponded
+	}
+	sc, err := stun.Dial("udp", addrs[0])
+	if err != nil {
+		return "", 0, err
+	}
+	defer sc.Close()
+
+	type result struct {
+		server string
+		e      stun.AgentEvent
+	}
+	results := make(chan result, len(addrs))
+	deadline := time.Now().Add(timeout)
+	for i, addr := range addrs {
+		server := names[i]
+		//事务记录发送时的服务器地址,切换后不影响已发送的事务
+		sc.ChangeServerAddr(addr.String())
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err := sc.SendMessage(m, deadline, func(e stun.AgentEvent) {
+			results <- result{server, e}
+		}); err != nil {
+			results <- result{server, stun.AgentEvent{Error: err}}
+		}
+	}
+
+	best, bestRTT := "", time.Duration(0)
+	for range addrs {
+		r := <-results
+		if r.e.Error != nil || r.e.Message.Type.Class != stun.ClassSuccessResponse {
+			log.Debugf("server %s fail, err:%v", r.server, r.e.Error)
+			continue
+		}
+		log.Debugf("server %s rtt:%s", r.server, r.e.RTT)
+		if best == "" || r.e.RTT < bestRTT {
+			best, bestRTT = r.server, r.e.RTT
+		}
+	}
+	if best == "" {
+		return "", 0, errNoServerResponded
+	}
+	return best, bestRTT, nil
+}
@@ -1,37 +1,58 @@
 package p2pclient
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/cocobao/cocostun/stun"
 )
 
 func NewP2PClient(server string, softwareName string) (*P2PClient, error) {
-	serverUDPAddr, err := net.ResolveUDPAddr("udp", server)
+	return NewP2PClientWithResolver(server, softwareName, nil)
+}
+
+//使用指定的DNS解析,r为空时使用系统解析
+func NewP2PClientWithResolver(server string, softwareName string, r stun.Resolver) (*P2PClient, error) {
+	//未指定端口时按SRV记录查找服务器
+	u, err := stun.ParseURI("stun:" + server)
+	if err != nil {
+		return nil, fmt.Errorf("Parse server addr fail")
+	}
+	addrs, err := stun.ResolveURI(context.Background(), r, u)
 	if err != nil {
 		return nil, fmt.Errorf("Resolve server addr fail")
 	}
-	sc, err := stun.Dial("udp", serverUDPAddr)
+	//解析出的所有地址都作为备用服务器,事务超时后切换到下一个
+	servers := make([]net.Addr, 0, len(addrs))
+	for _, a := range addrs {
+		addr, err := net.ResolveUDPAddr("udp", a)
+		if err != nil {
+			return nil, fmt.Errorf("Resolve server addr fail")
+		}
+		servers = append(servers, addr)
+	}
+	serverUDPAddr := servers[0].(*net.UDPAddr)
+	//探测时连续发送请求,按Ta间隔发送避免触发NAT或服务器的限速
+	sc, err := stun.DialWithOptions("udp", serverUDPAddr, stun.DialOptions{
+		Client: stun.ClientOptions{
+			Pace: stun.DefaultPace,
+		},
+	})
 	if err != nil {
 		return nil, err
 	}
+	sc.SetServers(servers)
 
-	var la string
-	if c, err := net.Dial("udp", server); err == nil {
-		la = c.LocalAddr().String()
+	//套接字绑定的是未指定地址,通过连接服务器获取实际出口ip
+	scad := sc.LocalAddr()
+	la := scad.String()
+	if c, err := net.DialUDP("udp", nil, serverUDPAddr); err == nil {
+		ip := c.LocalAddr().(*net.UDPAddr).IP
 		c.Close()
-
-		scad := sc.LocalAddr()
-		adss := strings.Split(scad, ":")
-		if len(adss) > 0 {
-			laadss := strings.Split(la, ":")
-			la = fmt.Sprintf("%s:%s", laadss[0], adss[len(adss)-1])
-		} else {
-			la = scad
-		}
+		la = net.JoinHostPort(ip.String(), strconv.Itoa(scad.Port))
 	}
 
 	cli := &P2PClient{
@@ -47,12 +68,13 @@ func NewP2PClient(server string, softwareName string) (*P2PClient, error) {
 
 type P2PClient struct {
 	serverHost   string
-	serverAddr   string
+	serverAddr   string // 主服务器,探测时可能切换到CHANGED-ADDRESS
 	softwareName string
 	sc           *stun.Client
 	localAddrStr string
 	mapAddrStr   *stun.Host
 	natType      NATType
+	rtt          time.Duration
 }
 
 func (c *P2PClient) ChangeServerAddr(addr string) {
@@ -67,6 +89,11 @@ func (c *P2PClient) GetNatType() string {
 	return c.natType.String()
 }
 
+//最近一次探测时到服务器的往返时间
+func (c *P2PClient) RTT() time.Duration {
+	return c.rtt
+}
+
 //发送绑定请求
 func (c *P2PClient) sendBindRequest(changeIP bool, changePort bool, callback func(res stun.AgentEvent)) {
 	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
@@ -62,14 +62,19 @@ func (c *P2PClient) TestIII(callback func(res stun.AgentEvent)) {
 //                                  +------>Restricted
 func (c *P2PClient) Discover(f func()) {
 	c.natType = NATError
+	//上次探测切换到了CHANGED-ADDRESS,先换回主服务器
 	c.ChangeServerAddr(c.serverAddr)
 	log.Debugf("----++++send testI %s ----++++", c.serverAddr)
 	c.TestI(func(res stun.AgentEvent) {
+		//超时后客户端会切换到下一个解析地址,作为之后探测的主服务器
+		c.serverAddr = c.sc.ServerAddr().String()
 		if res.Error != nil {
 			log.Warn(res.Error)
 			f()
 			return
 		}
+		c.rtt = res.RTT
+		log.Debugf("rtt:%s from %s", res.RTT, res.Remote)
 		log.Debug("local:", c.localAddrStr)
 		attInfos1 := res.Message.AsyncAttrbutes(c.localAddrStr)
 		if attInfos1.MappedAddr != nil {
@@ -0,0 +1,171 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+var (
+	ErrBadAddressLength = errors.New("bad address attribute length")
+	ErrBadAddressFamily = errors.New("bad address family")
+)
+
+//地址属性值,MAPPED-ADDRESS格式,也用于ALTERNATE-SERVER等属性
+type MappedAddress struct {
+	IP   net.IP
+	Port int
+}
+
+func (a MappedAddress) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+//按属性类型t添加地址属性
+func (a MappedAddress) AddToAs(m *Message, t AttrType) error {
+	family := familyIPv4
+	ip := a.IP.To4()
+	if ip == nil {
+		family = familyIPv6
+		ip = a.IP.To16()
+		if ip == nil {
+			return ErrBadAddressFamily
+		}
+	}
+	value := make([]byte, 4+len(ip))
+	bin.PutUint16(value[0:2], family)
+	bin.PutUint16(value[2:4], uint16(a.Port))
+	copy(value[4:], ip)
+	m.Add(t, value)
+	return nil
+}
+
+//按属性类型t读取地址属性
+func (a *MappedAddress) GetFromAs(m *Message, t AttrType) error {
+	v, err := m.Get(t)
+	if err != nil {
+		return err
+	}
+	if len(v) < 4 {
+		return ErrBadAddressLength
+	}
+	var size int
+	switch bin.Uint16(v[0:2]) {
+	case familyIPv4:
+		size = net.IPv4len
+	case familyIPv6:
+		size = net.IPv6len
+	default:
+		return ErrBadAddressFamily
+	}
+	if len(v) != 4+size {
+		return ErrBadAddressLength
+	}
+	a.Port = int(bin.Uint16(v[2:4]))
+	a.IP = append(net.IP(nil), v[4:]...)
+	return nil
+}
+
+func (a MappedAddress) AddTo(m *Message) error {
+	return a.AddToAs(m, AttrMappedAddress)
+}
+
+func (a *MappedAddress) GetFrom(m *Message) error {
+	return a.GetFromAs(m, AttrMappedAddress)
+}
+
+//ALTERNATE-SERVER属性,格式与MAPPED-ADDRESS相同
+type AlternateServer MappedAddress
+
+func (a AlternateServer) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a AlternateServer) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrAlternateServer)
+}
+
+func (a *AlternateServer) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrAlternateServer)
+}
+
+//ALTERNATE-DOMAIN属性(RFC 8489),TLS重定向时用于校验备用服务器证书
+type AlternateDomain string
+
+func (d AlternateDomain) AddTo(m *Message) error {
+	m.Add(AttrAlternateDomain, []byte(d))
+	return nil
+}
+
+func (d *AlternateDomain) GetFrom(m *Message) error {
+	v, err := m.Get(AttrAlternateDomain)
+	if err != nil {
+		return err
+	}
+	*d = AlternateDomain(v)
+	return nil
+}
+
+//XOR-MAPPED-ADDRESS属性,端口与magic cookie异或,IPv4地址与magic cookie异或,
+//IPv6地址与magic cookie和事务id异或
+type XORMappedAddress struct {
+	IP   net.IP
+	Port int
+}
+
+func (a XORMappedAddress) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+//按属性类型t添加异或地址属性,XOR-PEER-ADDRESS等属性格式相同
+func (a XORMappedAddress) AddToAs(m *Message, t AttrType) error {
+	family := familyIPv4
+	ip := a.IP.To4()
+	if ip == nil {
+		family = familyIPv6
+		ip = a.IP.To16()
+		if ip == nil {
+			return ErrBadAddressFamily
+		}
+	}
+	value := make([]byte, 4+len(ip))
+	bin.PutUint16(value[0:2], family)
+	bin.PutUint16(value[2:4], uint16(a.Port)^uint16(magicCookie>>16))
+	xorBytes(value[4:], ip, xorKey(m))
+	m.Add(t, value)
+	return nil
+}
+
+//按属性类型t读取异或地址属性
+func (a *XORMappedAddress) GetFromAs(m *Message, t AttrType) error {
+	var raw MappedAddress
+	if err := raw.GetFromAs(m, t); err != nil {
+		return err
+	}
+	a.Port = raw.Port ^ int(magicCookie>>16)
+	a.IP = make(net.IP, len(raw.IP))
+	xorBytes(a.IP, raw.IP, xorKey(m))
+	return nil
+}
+
+func (a XORMappedAddress) AddTo(m *Message) error {
+	return a.AddToAs(m, AttrXORMappedAddress)
+}
+
+func (a *XORMappedAddress) GetFrom(m *Message) error {
+	return a.GetFromAs(m, AttrXORMappedAddress)
+}
+
+//magic cookie和事务id
+func xorKey(m *Message) []byte {
+	key := make([]byte, 4+TransactionIDSize)
+	bin.PutUint32(key[0:4], magicCookie)
+	copy(key[4:], m.TransactionID[:])
+	return key
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range a {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,33 @@
+package stun_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+func TestXORMappedAddress(t *testing.T) {
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1"} {
+		m := stun.MustBuild(stun.TransactionID, stun.BindingSuccess)
+		a := stun.XORMappedAddress{IP: net.ParseIP(ip), Port: 32853}
+		if err := a.AddTo(m); err != nil {
+			t.Fatal(err)
+		}
+		v, _ := m.Get(stun.AttrXORMappedAddress)
+		if net.IP(v[4:]).Equal(a.IP) {
+			t.Fatalf("%s: address is not xored", ip)
+		}
+		d := &stun.Message{Raw: m.Raw}
+		if err := d.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		var got stun.XORMappedAddress
+		if err := got.GetFrom(d); err != nil {
+			t.Fatal(err)
+		}
+		if !got.IP.Equal(a.IP) || got.Port != a.Port {
+			t.Fatalf("got %s, want %s", got, a)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package stun
 
 import (
 	"errors"
+	"net"
 	"sync"
 	"time"
 )
@@ -16,18 +17,33 @@ var (
 	ErrTransactionExists    = errors.New("transaction exists with same id")
 	ErrTransactionStopped   = errors.New("transaction is stopped")
 	ErrTransactionNotExists = errors.New("transaction not exists")
+	ErrTooManyTransactions  = errors.New("too many transactions in flight")
 )
 
 type transactionID [TransactionIDSize]byte
 
 type AgentOptions struct {
 	Handler AgentFn // Default handler, can be nil.
+	Clock   Clock   // Defaults to SystemClock.
+
+	Observer Observer // Transaction hooks, can be nil.
+
+	MaxTransactions int // Maximum in-flight transactions, 0 is unlimited.
 }
 
 func NewAgent(o AgentOptions) *Agent {
 	a := &Agent{
 		transactions: make(map[transactionID]agentTransaction),
 		zeroHandler:  o.Handler,
+		clock:        o.Clock,
+		observer:     o.Observer,
+		max:          o.MaxTransactions,
+	}
+	if a.clock == nil {
+		a.clock = SystemClock
+	}
+	if a.observer == nil {
+		a.observer = nopObserver{}
 	}
 	return a
 }
@@ -37,6 +53,18 @@ type AgentFn func(e AgentEvent)
 type AgentEvent struct {
 	Message *Message
 	Error   error
+
+	Redirects []net.Addr // ALTERNATE-SERVER redirects followed by Client, if any
+
+	//事务开始发送和收到响应的时间,RTT为两者之差;
+	//有重传时RTT从第一次发送算起,按Karn算法估计RTO时应忽略Transmissions大于1的样本
+	Sent     time.Time
+	Received time.Time
+	RTT      time.Duration
+	//发送次数,只有Client会填充
+	Transmissions int
+	//响应的来源地址,只有Client会填充
+	Remote net.Addr
 }
 
 type Agent struct {
@@ -44,6 +72,9 @@ type Agent struct {
 	closed       bool       // all calls are invalid if true
 	mux          sync.Mutex // protects transactions and closed
 	zeroHandler  AgentFn    // handles non-registered transactions if set
+	clock        Clock
+	observer     Observer
+	max          int // in-flight limit, 0 is unlimited
 }
 
 func (a *Agent) StopWithError(id [TransactionIDSize]byte, err error) error {
@@ -58,6 +89,7 @@ func (a *Agent) StopWithError(id [TransactionIDSize]byte, err error) error {
 	if !exists {
 		return ErrTransactionNotExists
 	}
+	a.observer.TransactionStopped(id, err)
 	t.f(AgentEvent{
 		Error: err,
 	})
@@ -72,19 +104,27 @@ func (a *Agent) Stop(id [TransactionIDSize]byte) error {
 //启动代理
 func (a *Agent) Start(id [TransactionIDSize]byte, deadline time.Time, f AgentFn) error {
 	a.mux.Lock()
-	defer a.mux.Unlock()
 	if a.closed {
+		a.mux.Unlock()
 		return ErrAgentClosed
 	}
 	_, exists := a.transactions[id]
 	if exists {
+		a.mux.Unlock()
 		return ErrTransactionExists
 	}
+	if a.max > 0 && len(a.transactions) >= a.max {
+		a.mux.Unlock()
+		return ErrTooManyTransactions
+	}
 	a.transactions[id] = agentTransaction{
 		id:       id,
 		f:        f,
 		deadline: deadline,
+		start:    a.clock.Now(),
 	}
+	a.mux.Unlock()
+	a.observer.TransactionStarted(id, deadline)
 	return nil
 }
 
@@ -116,7 +156,8 @@ func (a *Agent) Collect(gcTime time.Time) error {
 		Error: ErrTransactionTimeOut,
 	}
 	//对超时的事务进行超时回调处理
-	for _, f := range toCall {
+	for i, f := range toCall {
+		a.observer.TransactionTimedOut(toRemove[i])
 		f(event)
 	}
 	return nil
@@ -136,6 +177,10 @@ func (a *Agent) Process(m *Message) error {
 	delete(a.transactions, m.TransactionID)
 	a.mux.Unlock()
 	if ok {
+		e.Sent = t.start
+		e.Received = a.clock.Now()
+		e.RTT = e.Received.Sub(e.Sent)
+		a.observer.TransactionResponded(m.TransactionID, m, e.RTT)
 		//消息事务回调
 		t.f(e)
 	} else if a.zeroHandler != nil {
@@ -163,11 +208,13 @@ func (a *Agent) Close() error {
 	a.closed = true
 	a.zeroHandler = nil
 	a.mux.Unlock()
+	a.observer.AgentClosed()
 	return nil
 }
 
 type agentTransaction struct {
 	id       transactionID
 	deadline time.Time
+	start    time.Time
 	f        AgentFn
 }
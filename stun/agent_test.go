@@ -0,0 +1,90 @@
+package stun_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+type recordObserver struct {
+	events []string
+}
+
+func (o *recordObserver) TransactionStarted(id [stun.TransactionIDSize]byte, deadline time.Time) {
+	o.events = append(o.events, fmt.Sprintf("start %x", id[0]))
+}
+
+func (o *recordObserver) TransactionResponded(id [stun.TransactionIDSize]byte, m *stun.Message, rtt time.Duration) {
+	o.events = append(o.events, fmt.Sprintf("response %x %s", id[0], rtt))
+}
+
+func (o *recordObserver) TransactionTimedOut(id [stun.TransactionIDSize]byte) {
+	o.events = append(o.events, fmt.Sprintf("timeout %x", id[0]))
+}
+
+func (o *recordObserver) TransactionStopped(id [stun.TransactionIDSize]byte, err error) {
+	o.events = append(o.events, fmt.Sprintf("stop %x", id[0]))
+}
+
+func (o *recordObserver) AgentClosed() {
+	o.events = append(o.events, "close")
+}
+
+func TestAgentObserver(t *testing.T) {
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	o := new(recordObserver)
+	a := stun.NewAgent(stun.AgentOptions{
+		Clock:    clock,
+		Observer: o,
+	})
+	nop := func(stun.AgentEvent) {}
+	deadline := clock.Now().Add(time.Second)
+	for i := byte(1); i <= 3; i++ {
+		if err := a.Start([stun.TransactionIDSize]byte{i}, deadline, nop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Millisecond * 30)
+	if err := a.Process(&stun.Message{TransactionID: [stun.TransactionIDSize]byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Stop([stun.TransactionIDSize]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Collect(deadline.Add(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start 1", "start 2", "start 3", "response 1 30ms", "stop 2", "timeout 3", "close"}
+	if fmt.Sprint(o.events) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", o.events, want)
+	}
+}
+
+func TestAgentMaxTransactions(t *testing.T) {
+	a := stun.NewAgent(stun.AgentOptions{
+		MaxTransactions: 2,
+	})
+	defer a.Close()
+	nop := func(stun.AgentEvent) {}
+	deadline := time.Now().Add(time.Minute)
+	for i := byte(1); i <= 2; i++ {
+		if err := a.Start([stun.TransactionIDSize]byte{i}, deadline, nop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Start([stun.TransactionIDSize]byte{3}, deadline, nop); err != stun.ErrTooManyTransactions {
+		t.Fatalf("got %v, want %v", err, stun.ErrTooManyTransactions)
+	}
+	if err := a.Stop([stun.TransactionIDSize]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start([stun.TransactionIDSize]byte{3}, deadline, nop); err != nil {
+		t.Fatal(err)
+	}
+}
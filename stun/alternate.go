@@ -0,0 +1,84 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	ErrRedirected = errors.New("connection redirected to alternate server")
+)
+
+//最多重定向次数
+const maxRedirects = 3
+
+//支持重定向的连接,流式连接需要重新连接到备用服务器
+type redirector interface {
+	Redirect(addr net.Addr, domain string) error
+}
+
+//处理300 Try Alternate响应,重定向成功时返回true,事务结果由新事务回调
+func (c *Client) tryAlternate(t *clientTransaction, m *Message) bool {
+	if m.Type.Class != ClassErrorResponse {
+		return false
+	}
+	var code ErrorCodeAttribute
+	if err := code.GetFrom(m); err != nil || code.Code != CodeTryAlternate {
+		return false
+	}
+	var alt AlternateServer
+	if err := alt.GetFrom(m); err != nil {
+		return false
+	}
+	var domain AlternateDomain
+	domain.GetFrom(m)
+
+	visited := t.visited
+	if len(visited) == 0 {
+		visited = []net.Addr{t.addr}
+	}
+	if len(visited) > maxRedirects {
+		return false
+	}
+	r, stream := c.serConn.(redirector)
+	var addr net.Addr = &net.UDPAddr{IP: alt.IP, Port: alt.Port}
+	if stream {
+		addr = &net.TCPAddr{IP: alt.IP, Port: alt.Port}
+	}
+	//防止重定向循环
+	for _, v := range visited {
+		if sameAddr(v, addr) {
+			return false
+		}
+	}
+	visited = append(append([]net.Addr(nil), visited...), addr)
+
+	//向备用服务器发起新事务(RFC 5389 11)
+	req, err := rebuildRequest(t.req, true)
+	if err == nil && stream {
+		//旧连接上的其他事务不会再收到响应
+		pending := c.transactionIDs()
+		if err = r.Redirect(addr, string(domain)); err == nil {
+			c.addrMux.Lock()
+			c.serAddr = addr
+			c.addrMux.Unlock()
+			for _, id := range pending {
+				c.stopWithError(id, ErrRedirected)
+			}
+		}
+	}
+	if err == nil {
+		err = c.start(req, addr, t.deadline, t.f, &clientTransaction{
+			visited:   visited,
+			authTries: t.authTries,
+		})
+	}
+	if err != nil {
+		t.f(AgentEvent{
+			Error:     fmt.Errorf("redirect to %s: %v", addr, err),
+			Redirects: visited[1:],
+		})
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package stun_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//回复300 Try Alternate,ALTERNATE-SERVER指向alternate
+func tryAlternate(t *testing.T, alternate *stun.MappedAddress) func(req *stun.Message) *stun.Message {
+	return func(req *stun.Message) *stun.Message {
+		res, err := stun.Build(stun.BindingError, stun.CodeTryAlternate, stun.AlternateServer(*alternate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.TransactionID = req.TransactionID
+		res.WriteTransactionID()
+		return res
+	}
+}
+
+func TestClientAlternateServer(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	alternate := listenLoopback(t)
+	defer alternate.Close()
+	c := stun.NewClient(listenLoopback(t), server.LocalAddr())
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, time.Now().Add(time.Second*3), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	altAddr := alternate.LocalAddr().(*net.UDPAddr)
+	respondWith(t, server, server, tryAlternate(t, &stun.MappedAddress{IP: altAddr.IP, Port: altAddr.Port}))
+	//重定向是新事务,使用新的事务id
+	respondWith(t, alternate, alternate, func(req *stun.Message) *stun.Message {
+		if req.TransactionID == m.TransactionID {
+			t.Error("redirected request reused transaction id")
+		}
+		res := stun.MustBuild(stun.BindingSuccess)
+		res.TransactionID = req.TransactionID
+		res.WriteTransactionID()
+		return res
+	})
+	e := <-done
+	if e.Error != nil {
+		t.Fatalf("unexpected error: %v", e.Error)
+	}
+	if e.Message.Type != stun.BindingSuccess {
+		t.Fatalf("unexpected response: %v", e.Message.Type)
+	}
+	if len(e.Redirects) != 1 || e.Redirects[0].String() != altAddr.String() {
+		t.Fatalf("redirects: %v", e.Redirects)
+	}
+}
+
+func TestClientAlternateServerLoop(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	alternate := listenLoopback(t)
+	defer alternate.Close()
+	c := stun.NewClient(listenLoopback(t), server.LocalAddr())
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, time.Now().Add(time.Second*3), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	serverAddr := server.LocalAddr().(*net.UDPAddr)
+	altAddr := alternate.LocalAddr().(*net.UDPAddr)
+	respondWith(t, server, server, tryAlternate(t, &stun.MappedAddress{IP: altAddr.IP, Port: altAddr.Port}))
+	//备用服务器又指回原服务器,客户端不再跟随
+	respondWith(t, alternate, alternate, tryAlternate(t, &stun.MappedAddress{IP: serverAddr.IP, Port: serverAddr.Port}))
+	e := <-done
+	if e.Error != nil {
+		t.Fatalf("unexpected error: %v", e.Error)
+	}
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(e.Message); err != nil || code.Code != stun.CodeTryAlternate {
+		t.Fatalf("got %v %v, want 300 error response", code, err)
+	}
+	if len(e.Redirects) != 1 {
+		t.Fatalf("redirects: %v", e.Redirects)
+	}
+}
+
+//流连接重定向后,旧连接上的其他事务立即失败
+func TestClientAlternateServerStream(t *testing.T) {
+	alternate, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alternate.Close()
+	go serveStream(t, alternate, 1)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	altAddr := alternate.Addr().(*net.TCPAddr)
+	//第一个请求不回复,第二个请求回复300
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1500)
+		for i := 0; i < 2; i++ {
+			n, err := stun.ReadFrame(conn, buf)
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				continue
+			}
+			req := &stun.Message{Raw: buf[:n]}
+			if err = req.Decode(); err != nil {
+				t.Error(err)
+				return
+			}
+			res := tryAlternate(t, &stun.MappedAddress{IP: altAddr.IP, Port: altAddr.Port})(req)
+			if _, err = conn.Write(res.Raw); err != nil {
+				return
+			}
+		}
+		//等待客户端关闭旧连接
+		stun.ReadFrame(conn, buf)
+	}()
+
+	c, err := stun.DialTCP("tcp4", l.Addr().String(), stun.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	pending := make(chan stun.AgentEvent, 1)
+	redirected := make(chan stun.AgentEvent, 1)
+	deadline := time.Now().Add(time.Second * 5)
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, func(e stun.AgentEvent) {
+		pending <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, func(e stun.AgentEvent) {
+		redirected <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-redirected; e.Error != nil {
+		t.Fatalf("redirected: %v", e.Error)
+	}
+	select {
+	case e := <-pending:
+		if e.Error != stun.ErrRedirected {
+			t.Fatalf("pending: got %v, want %v", e.Error, stun.ErrRedirected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending transaction not failed after redirect")
+	}
+}
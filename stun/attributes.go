@@ -1,12 +1,18 @@
 package stun
 
 import (
+	"errors"
+	"fmt"
 	"hash/crc32"
 	"net"
 
 	"github.com/cocobao/cocostun/utils"
 )
 
+var (
+	ErrFingerprintMismatch = errors.New("fingerprint check failed")
+)
+
 type Attributes []RawAttribute
 
 func (a Attributes) Get(t AttrType) (RawAttribute, bool) {
@@ -85,6 +91,30 @@ func (t AttrType) Value() uint16 {
 	return uint16(t)
 }
 
+var attrNames = map[AttrType]string{
+	AttrMappedAddress:     "MAPPED-ADDRESS",
+	AttrUsername:          "USERNAME",
+	AttrMessageIntegrity:  "MESSAGE-INTEGRITY",
+	AttrErrorCode:         "ERROR-CODE",
+	AttrUnknownAttributes: "UNKNOWN-ATTRIBUTES",
+	AttrRealm:             "REALM",
+	AttrNonce:             "NONCE",
+	AttrXORMappedAddress:  "XOR-MAPPED-ADDRESS",
+	AttrSoftware:          "SOFTWARE",
+	AttrAlternateServer:   "ALTERNATE-SERVER",
+	AttrAlternateDomain:   "ALTERNATE-DOMAIN",
+	AttrFingerprint:       "FINGERPRINT",
+}
+
+func (t AttrType) String() string {
+	s, ok := attrNames[t]
+	if !ok {
+		// Falling back to hex representation.
+		s = fmt.Sprintf("0x%x", uint16(t))
+	}
+	return s
+}
+
 // Attributes from comprehension-required range (0x0000-0x7FFF).
 const (
 	AttrMappedAddress          AttrType = 0x0001 // MAPPED-ADDRESS
@@ -113,6 +143,7 @@ const (
 
 // Attributes from comprehension-optional range (0x8000-0xFFFF).
 const (
+	AttrAlternateDomain     AttrType = 0x8003 // ALTERNATE-DOMAIN
 	AttrXorMappedAddressExp AttrType = 0x8020
 	AttrSoftware            AttrType = 0x8022 // SOFTWARE
 	AttrAlternateServer     AttrType = 0x8023 // ALTERNATE-SERVER
@@ -152,12 +183,41 @@ func (m *Message) AddSoftwareAttribute(name string) {
 	m.Add(AttrSoftware, []byte(name))
 }
 
-//添加指纹属性
+//指纹属性值长度
+const fingerprintSize = 4
+
+//添加指纹属性,CRC计算时头部长度需包含指纹属性本身
 func (m *Message) AddFingerprintAttribute() {
-	crc := crc32.ChecksumIEEE(m.Raw) ^ fingerprint
-	buf := make([]byte, 4)
+	l := m.Length
+	m.Length += attributeHeaderSize + fingerprintSize
+	m.WriteLength()
+	crc := crc32.ChecksumIEEE(m.Raw[:messageHeaderSize+int(l)]) ^ fingerprint
+	m.Length = l
+	buf := make([]byte, fingerprintSize)
 	bin.PutUint32(buf, crc)
-	m.Add(AttrSoftware, []byte(buf))
+	m.Add(AttrFingerprint, buf)
+}
+
+//校验指纹属性,没有指纹属性时返回nil
+func (m *Message) CheckFingerprint() error {
+	n := len(m.Attributes)
+	if n == 0 || m.Attributes[n-1].Type != AttrFingerprint {
+		if _, ok := m.Attributes.Get(AttrFingerprint); ok {
+			//指纹属性必须是最后一个属性
+			return ErrFingerprintMismatch
+		}
+		return nil
+	}
+	v := m.Attributes[n-1].Value
+	end := messageHeaderSize + int(m.Length) - attributeHeaderSize - fingerprintSize
+	if len(v) != fingerprintSize || end < messageHeaderSize || end > len(m.Raw) {
+		return ErrFingerprintMismatch
+	}
+	crc := crc32.ChecksumIEEE(m.Raw[:end]) ^ fingerprint
+	if bin.Uint32(v) != crc {
+		return ErrFingerprintMismatch
+	}
+	return nil
 }
 
 //添加切换端口或ip请求
@@ -0,0 +1,131 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+)
+
+//最多认证重发次数,防止服务器反复返回438
+const maxAuthTries = 3
+
+//服务器的长期凭证状态
+type authState struct {
+	realm string
+	nonce string
+}
+
+func (c *Client) authState(addr net.Addr) (authState, bool) {
+	if c.username == "" {
+		return authState{}, false
+	}
+	c.authMux.Lock()
+	defer c.authMux.Unlock()
+	s, ok := c.auth[addrKey(addr)]
+	return s, ok
+}
+
+func (c *Client) setAuthState(addr net.Addr, s authState) {
+	c.authMux.Lock()
+	c.auth[addrKey(addr)] = s
+	c.authMux.Unlock()
+}
+
+//给请求加上USERNAME、REALM、NONCE和MESSAGE-INTEGRITY
+func (c *Client) authenticate(raw []byte, s authState) (*Message, error) {
+	return rebuildRequest(raw, false,
+		Username(c.username),
+		Realm(s.realm),
+		Nonce(s.nonce),
+		NewLongTermIntegrity(c.username, s.realm, c.password),
+	)
+}
+
+//按原始请求重新构造消息,newID时换新的事务id,
+//setters添加在FINGERPRINT之前,原请求带FINGERPRINT时重新计算
+func rebuildRequest(raw []byte, newID bool, setters ...Setter) (*Message, error) {
+	m := &Message{Raw: append([]byte(nil), raw...)}
+	if err := m.Decode(); err != nil {
+		return nil, err
+	}
+	n := len(m.Attributes)
+	fingerprint := n > 0 && m.Attributes[n-1].Type == AttrFingerprint
+	if fingerprint {
+		m.Attributes = m.Attributes[:n-1]
+		m.Length -= attributeHeaderSize + fingerprintSize
+		m.Raw = m.Raw[:messageHeaderSize+int(m.Length)]
+		m.WriteLength()
+	}
+	if newID {
+		if err := m.NewTransactionID(); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range setters {
+		if err := s.AddTo(m); err != nil {
+			return nil, err
+		}
+	}
+	if fingerprint {
+		m.AddFingerprintAttribute()
+	}
+	return m, nil
+}
+
+//处理401 Unauthorized和438 Stale Nonce,记录服务器的realm和nonce后用新的事务id重发,
+//重发成功时返回true,事务结果由新事务回调
+func (c *Client) tryAuthenticate(t *clientTransaction, m *Message) bool {
+	if c.username == "" || m.Type.Class != ClassErrorResponse {
+		return false
+	}
+	var code ErrorCodeAttribute
+	if err := code.GetFrom(m); err != nil {
+		return false
+	}
+	switch code.Code {
+	case CodeUnauthorized:
+		//已经带了凭证还返回401,说明用户名或密码错误
+		if t.key != nil {
+			return false
+		}
+	case CodeStaleNonce:
+	default:
+		return false
+	}
+	if t.authTries >= maxAuthTries {
+		return false
+	}
+	var nonce Nonce
+	if err := nonce.GetFrom(m); err != nil {
+		return false
+	}
+	var realm Realm
+	if err := realm.GetFrom(m); err != nil {
+		s, ok := c.authState(t.addr)
+		if !ok {
+			return false
+		}
+		realm = Realm(s.realm)
+	}
+	c.setAuthState(t.addr, authState{
+		realm: string(realm),
+		nonce: string(nonce),
+	})
+
+	req, err := rebuildRequest(t.req, true)
+	if err == nil {
+		err = c.start(req, t.addr, t.deadline, t.f, &clientTransaction{
+			visited:   t.visited,
+			authTries: t.authTries + 1,
+		})
+	}
+	if err != nil {
+		e := AgentEvent{
+			Error: fmt.Errorf("authenticate: %v", err),
+		}
+		if len(t.visited) > 1 {
+			e.Redirects = t.visited[1:]
+		}
+		t.f(e)
+	}
+	return true
+}
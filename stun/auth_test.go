@@ -0,0 +1,173 @@
+package stun_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//设置事务id,需在MESSAGE-INTEGRITY之前
+type transactionID [stun.TransactionIDSize]byte
+
+func (id transactionID) AddTo(m *stun.Message) error {
+	m.TransactionID = id
+	m.WriteTransactionID()
+	return nil
+}
+
+//构造响应,事务id与请求相同
+func reply(t *testing.T, req *stun.Message, setters ...stun.Setter) *stun.Message {
+	t.Helper()
+	res, err := stun.Build(append([]stun.Setter{transactionID(req.TransactionID)}, setters...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+//校验请求带有正确的长期凭证
+func checkCredentials(t *testing.T, req *stun.Message, nonce, password string) {
+	t.Helper()
+	var (
+		u stun.Username
+		r stun.Realm
+		n stun.Nonce
+	)
+	if err := u.GetFrom(req); err != nil || u != "user" {
+		t.Fatalf("username %q, %v", u, err)
+	}
+	if err := r.GetFrom(req); err != nil || r != "realm" {
+		t.Fatalf("realm %q, %v", r, err)
+	}
+	if err := n.GetFrom(req); err != nil || string(n) != nonce {
+		t.Fatalf("nonce %q, %v, want %q", n, err, nonce)
+	}
+	if err := stun.NewLongTermIntegrity("user", "realm", password).Check(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientLongTermCredentials(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Username:   "user",
+		Password:   "pass",
+	})
+	defer c.Close()
+	key := stun.NewLongTermIntegrity("user", "realm", "pass")
+
+	send := func() chan stun.AgentEvent {
+		done := make(chan stun.AgentEvent, 1)
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err := c.SendMessage(m, time.Now().Add(time.Second*3), func(e stun.AgentEvent) {
+			done <- e
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+	success := func(req *stun.Message) *stun.Message {
+		res := reply(t, req, stun.BindingSuccess, key)
+		res.AddFingerprintAttribute()
+		return res
+	}
+
+	//第一次请求不带凭证,收到401后带凭证重发
+	done := send()
+	var first [stun.TransactionIDSize]byte
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		if _, err := req.Get(stun.AttrMessageIntegrity); err == nil {
+			t.Fatal("unexpected MESSAGE-INTEGRITY in first request")
+		}
+		first = req.TransactionID
+		return reply(t, req, stun.BindingError, stun.CodeUnauthorized, stun.Realm("realm"), stun.Nonce("n1"))
+	})
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		if req.TransactionID == first {
+			t.Fatal("transaction id is not changed")
+		}
+		checkCredentials(t, req, "n1", "pass")
+		return success(req)
+	})
+	if e := <-done; e.Error != nil || e.Message.Type != stun.BindingSuccess {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	//缓存了nonce,直接带凭证;nonce过期返回438后用新nonce重发
+	done = send()
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		checkCredentials(t, req, "n1", "pass")
+		return reply(t, req, stun.BindingError, stun.CodeStaleNonce, stun.Realm("realm"), stun.Nonce("n2"))
+	})
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		checkCredentials(t, req, "n2", "pass")
+		return success(req)
+	})
+	if e := <-done; e.Error != nil || e.Message.Type != stun.BindingSuccess {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func TestClientLongTermCredentialsRejected(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Username:   "user",
+		Password:   "wrong",
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, time.Now().Add(time.Second*3), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unauthorized := func(req *stun.Message) *stun.Message {
+		return reply(t, req, stun.BindingError, stun.CodeUnauthorized, stun.Realm("realm"), stun.Nonce("n1"))
+	}
+	respondWith(t, server, server, unauthorized)
+	respondWith(t, server, server, unauthorized)
+	e := <-done
+	var code stun.ErrorCodeAttribute
+	if e.Message == nil || code.GetFrom(e.Message) != nil || code.Code != stun.CodeUnauthorized {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func TestClientIntegrityMismatch(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Username:   "user",
+		Password:   "pass",
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, time.Now().Add(time.Millisecond*500), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		return reply(t, req, stun.BindingError, stun.CodeUnauthorized, stun.Realm("realm"), stun.Nonce("n1"))
+	})
+	//成功响应的MESSAGE-INTEGRITY错误,响应被丢弃
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		return reply(t, req, stun.BindingSuccess, stun.NewLongTermIntegrity("user", "realm", "other"))
+	})
+	if e := <-done; e.Error != stun.ErrTransactionTimeOut {
+		t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+	}
+}
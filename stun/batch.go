@@ -0,0 +1,62 @@
+package stun
+
+import (
+	"net"
+)
+
+//批量收发的数据报
+type BatchMessage struct {
+	//读取时的缓冲区,写入时发送全部数据,长度不能为0
+	Buf []byte
+	//读取到的数据长度
+	N int
+	//读取时为来源地址,写入时为目的地址
+	Addr net.Addr
+}
+
+//批量收发接口,ReadBatch至少读到一个数据报才返回,WriteBatch返回已发送的数量
+type BatchConn interface {
+	ReadBatch(ms []BatchMessage) (int, error)
+	WriteBatch(ms []BatchMessage) (int, error)
+}
+
+//Linux上的*net.UDPConn使用recvmmsg/sendmmsg,其他情况使用NewPacketBatchConn
+func NewBatchConn(conn net.PacketConn) BatchConn {
+	if udp, ok := conn.(*net.UDPConn); ok {
+		if b, err := newMmsgConn(udp); err == nil {
+			return b
+		}
+	}
+	return NewPacketBatchConn(conn)
+}
+
+//逐个调用ReadFrom/WriteTo的通用实现,每次ReadBatch只读一个数据报
+func NewPacketBatchConn(conn net.PacketConn) BatchConn {
+	return packetBatchConn{conn}
+}
+
+type packetBatchConn struct {
+	conn net.PacketConn
+}
+
+func (p packetBatchConn) ReadBatch(ms []BatchMessage) (int, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+	n, addr, err := p.conn.ReadFrom(ms[0].Buf)
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N = n
+	ms[0].Addr = addr
+	return 1, nil
+}
+
+func (p packetBatchConn) WriteBatch(ms []BatchMessage) (int, error) {
+	for i := range ms {
+		if _, err := p.conn.WriteTo(ms[i].Buf, ms[i].Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(ms), nil
+}
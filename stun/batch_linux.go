@@ -0,0 +1,238 @@
+//go:build linux && (amd64 || arm64)
+
+package stun
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var errMmsgAddr = errors.New("mmsg: unsupported address")
+
+// struct mmsghdr
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+	_   [4]byte
+}
+
+//每次调用的临时缓冲区,读写各一份
+type mmsgBuffers struct {
+	mux   sync.Mutex
+	hdrs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrAny
+}
+
+func (b *mmsgBuffers) reset(n int) {
+	if cap(b.hdrs) < n {
+		b.hdrs = make([]mmsghdr, n)
+		b.iovs = make([]syscall.Iovec, n)
+		b.names = make([]syscall.RawSockaddrAny, n)
+	}
+	b.hdrs = b.hdrs[:n]
+	b.iovs = b.iovs[:n]
+	b.names = b.names[:n]
+	for i := range b.hdrs {
+		b.hdrs[i] = mmsghdr{}
+		b.hdrs[i].hdr.Iov = &b.iovs[i]
+		b.hdrs[i].hdr.Iovlen = 1
+	}
+}
+
+//基于recvmmsg/sendmmsg的批量收发
+type mmsgConn struct {
+	rc    syscall.RawConn
+	inet6 bool // AF_INET6套接字,IPv4地址需转换为映射地址
+	r     mmsgBuffers
+	w     mmsgBuffers
+}
+
+func newMmsgConn(conn *net.UDPConn) (*mmsgConn, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		sa     syscall.Sockaddr
+		saErr  error
+		result = &mmsgConn{rc: rc}
+	)
+	if err = rc.Control(func(fd uintptr) {
+		sa, saErr = syscall.Getsockname(int(fd))
+	}); err != nil {
+		return nil, err
+	}
+	if saErr != nil {
+		return nil, saErr
+	}
+	_, result.inet6 = sa.(*syscall.SockaddrInet6)
+	return result, nil
+}
+
+func (p *mmsgConn) ReadBatch(ms []BatchMessage) (int, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+	b := &p.r
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.reset(len(ms))
+	for i := range ms {
+		b.iovs[i].Base = &ms[i].Buf[0]
+		b.iovs[i].SetLen(len(ms[i].Buf))
+		b.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.hdrs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+	}
+	var (
+		n     int
+		errno syscall.Errno
+	)
+	err := p.rc.Read(func(fd uintptr) bool {
+		for {
+			r, _, e := syscall.Syscall6(sysRecvmmsg, fd, uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(len(b.hdrs)), 0, 0, 0)
+			switch e {
+			case syscall.EINTR:
+				continue
+			case syscall.EAGAIN:
+				return false
+			}
+			n, errno = int(r), e
+			return true
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, os.NewSyscallError("recvmmsg", errno)
+	}
+	for i := 0; i < n; i++ {
+		ms[i].N = int(b.hdrs[i].len)
+		ms[i].Addr = sockaddrToUDP(&b.names[i])
+	}
+	return n, nil
+}
+
+func (p *mmsgConn) WriteBatch(ms []BatchMessage) (int, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+	b := &p.w
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.reset(len(ms))
+	for i := range ms {
+		b.iovs[i].Base = &ms[i].Buf[0]
+		b.iovs[i].SetLen(len(ms[i].Buf))
+		if ms[i].Addr == nil {
+			continue
+		}
+		addr, ok := ms[i].Addr.(*net.UDPAddr)
+		if !ok {
+			return 0, errMmsgAddr
+		}
+		l, err := putSockaddr(&b.names[i], addr, p.inet6)
+		if err != nil {
+			return 0, err
+		}
+		b.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.hdrs[i].hdr.Namelen = l
+	}
+	sent := 0
+	var errno syscall.Errno
+	err := p.rc.Write(func(fd uintptr) bool {
+		for sent < len(b.hdrs) {
+			r, _, e := syscall.Syscall6(sysSendmmsg, fd, uintptr(unsafe.Pointer(&b.hdrs[sent])), uintptr(len(b.hdrs)-sent), 0, 0, 0)
+			switch e {
+			case 0:
+				sent += int(r)
+				continue
+			case syscall.EINTR:
+				continue
+			case syscall.EAGAIN:
+				return false
+			}
+			errno = e
+			return true
+		}
+		return true
+	})
+	if err != nil {
+		return sent, err
+	}
+	if errno != 0 {
+		return sent, os.NewSyscallError("sendmmsg", errno)
+	}
+	return sent, nil
+}
+
+func sockaddrToUDP(sa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case syscall.AF_INET:
+		in := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		port := (*[2]byte)(unsafe.Pointer(&in.Port))
+		return &net.UDPAddr{
+			IP:   net.IPv4(in.Addr[0], in.Addr[1], in.Addr[2], in.Addr[3]),
+			Port: int(port[0])<<8 | int(port[1]),
+		}
+	case syscall.AF_INET6:
+		in := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		port := (*[2]byte)(unsafe.Pointer(&in.Port))
+		addr := &net.UDPAddr{
+			IP:   append(net.IP(nil), in.Addr[:]...),
+			Port: int(port[0])<<8 | int(port[1]),
+		}
+		if in.Scope_id != 0 {
+			addr.Zone = zoneName(int(in.Scope_id))
+		}
+		return addr
+	}
+	return nil
+}
+
+//写入目的地址,返回地址长度
+func putSockaddr(sa *syscall.RawSockaddrAny, addr *net.UDPAddr, inet6 bool) (uint32, error) {
+	ip4 := addr.IP.To4()
+	if ip4 != nil && !inet6 {
+		in := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		*in = syscall.RawSockaddrInet4{Family: syscall.AF_INET}
+		port := (*[2]byte)(unsafe.Pointer(&in.Port))
+		port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+		copy(in.Addr[:], ip4)
+		return syscall.SizeofSockaddrInet4, nil
+	}
+	ip := addr.IP.To16()
+	if ip == nil || !inet6 {
+		return 0, errMmsgAddr
+	}
+	in := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+	*in = syscall.RawSockaddrInet6{Family: syscall.AF_INET6}
+	port := (*[2]byte)(unsafe.Pointer(&in.Port))
+	port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+	copy(in.Addr[:], ip)
+	if addr.Zone != "" {
+		in.Scope_id = uint32(zoneIndex(addr.Zone))
+	}
+	return syscall.SizeofSockaddrInet6, nil
+}
+
+func zoneName(index int) string {
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		return ifi.Name
+	}
+	return strconv.Itoa(index)
+}
+
+func zoneIndex(zone string) int {
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return ifi.Index
+	}
+	n, _ := strconv.Atoi(zone)
+	return n
+}
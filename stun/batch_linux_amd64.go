@@ -0,0 +1,7 @@
+package stun
+
+//syscall包在amd64上没有定义SYS_SENDMMSG
+const (
+	sysRecvmmsg = 299
+	sysSendmmsg = 307
+)
@@ -0,0 +1,8 @@
+package stun
+
+import "syscall"
+
+const (
+	sysRecvmmsg = syscall.SYS_RECVMMSG
+	sysSendmmsg = syscall.SYS_SENDMMSG
+)
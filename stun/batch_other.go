@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+
+package stun
+
+import (
+	"errors"
+	"net"
+)
+
+func newMmsgConn(conn *net.UDPConn) (BatchConn, error) {
+	return nil, errors.New("mmsg: not supported on this platform")
+}
@@ -0,0 +1,150 @@
+package stun_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var batchConns = []struct {
+	name string
+	new  func(net.PacketConn) stun.BatchConn
+}{
+	{"mmsg", stun.NewBatchConn},
+	{"portable", stun.NewPacketBatchConn},
+}
+
+//读取n个数据报
+func readBatch(t testing.TB, b stun.BatchConn, ms []stun.BatchMessage, n int) []stun.BatchMessage {
+	var result []stun.BatchMessage
+	for len(result) < n {
+		got, err := b.ReadBatch(ms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, ms[:got]...)
+	}
+	return result
+}
+
+func TestBatchConn(t *testing.T) {
+	for _, bc := range batchConns {
+		t.Run(bc.name, func(t *testing.T) {
+			a := listenLoopback(t)
+			defer a.Close()
+			b := listenLoopback(t)
+			defer b.Close()
+			w, r := bc.new(a), bc.new(b)
+
+			out := make([]stun.BatchMessage, 8)
+			for i := range out {
+				out[i] = stun.BatchMessage{
+					Buf:  []byte(fmt.Sprintf("packet %d", i)),
+					Addr: b.LocalAddr(),
+				}
+			}
+			n, err := w.WriteBatch(out)
+			if err != nil || n != len(out) {
+				t.Fatalf("write %d, %v", n, err)
+			}
+			if err = b.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+				t.Fatal(err)
+			}
+			in := make([]stun.BatchMessage, 4)
+			for i := range in {
+				in[i].Buf = make([]byte, 1500)
+			}
+			var got []string
+			for len(got) < len(out) {
+				n, err := r.ReadBatch(in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, m := range in[:n] {
+					if m.Addr.String() != a.LocalAddr().String() {
+						t.Fatalf("got addr %s, want %s", m.Addr, a.LocalAddr())
+					}
+					got = append(got, string(m.Buf[:m.N]))
+				}
+			}
+			for i, s := range got {
+				if want := fmt.Sprintf("packet %d", i); s != want {
+					t.Fatalf("got %q, want %q", s, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		BatchSize:  8,
+	})
+	defer c.Close()
+
+	const count = 16
+	done := make(chan stun.AgentEvent, count)
+	ms := make([]*stun.Message, count)
+	for i := range ms {
+		ms[i] = stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	}
+	if err := c.StartBatch(ms, time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		respond(t, server, server, stun.BindingSuccess)
+	}
+	for i := 0; i < count; i++ {
+		if e := <-done; e.Error != nil {
+			t.Fatal(e.Error)
+		}
+	}
+}
+
+//回环上每次发送并接收size个数据报
+func BenchmarkBatchConn(b *testing.B) {
+	const size = 32
+	for _, bc := range batchConns {
+		b.Run(bc.name, func(b *testing.B) {
+			a, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer a.Close()
+			r, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer r.Close()
+			if err = r.SetReadBuffer(1 << 20); err != nil {
+				b.Fatal(err)
+			}
+			w, rb := bc.new(a), bc.new(r)
+
+			req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+			out := make([]stun.BatchMessage, size)
+			in := make([]stun.BatchMessage, size)
+			for i := range out {
+				out[i] = stun.BatchMessage{Buf: req.Raw, Addr: r.LocalAddr()}
+				in[i].Buf = make([]byte, 1500)
+			}
+			b.SetBytes(int64(len(req.Raw) * size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.WriteBatch(out); err != nil {
+					b.Fatal(err)
+				}
+				readBatch(b, rb, in, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package stun
+
+import (
+	"errors"
+)
+
+var (
+	ErrBadAttributeLength = errors.New("bad attribute length")
+)
+
+//RFC 5780 NAT行为发现使用的属性
+
+//CHANGE-REQUEST属性,要求服务器从另一个IP或端口回复
+type ChangeRequest struct {
+	ChangeIP   bool
+	ChangePort bool
+}
+
+func (c ChangeRequest) AddTo(m *Message) error {
+	m.AddChangeReqAttribute(c.ChangeIP, c.ChangePort)
+	return nil
+}
+
+func (c *ChangeRequest) GetFrom(m *Message) error {
+	v, err := m.Get(AttrChangeRequest)
+	if err != nil {
+		return err
+	}
+	if len(v) != 4 {
+		return ErrBadAttributeLength
+	}
+	c.ChangeIP = v[3]&0x04 != 0
+	c.ChangePort = v[3]&0x02 != 0
+	return nil
+}
+
+//RESPONSE-PORT属性,要求服务器把响应发到客户端IP的该端口
+type ResponsePort int
+
+func (p ResponsePort) AddTo(m *Message) error {
+	v := make([]byte, 4)
+	bin.PutUint16(v[0:2], uint16(p))
+	m.Add(AttrResponsePort, v)
+	return nil
+}
+
+func (p *ResponsePort) GetFrom(m *Message) error {
+	v, err := m.Get(AttrResponsePort)
+	if err != nil {
+		return err
+	}
+	if len(v) != 4 {
+		return ErrBadAttributeLength
+	}
+	*p = ResponsePort(bin.Uint16(v[0:2]))
+	return nil
+}
+
+//PADDING属性,值为指定长度的0,用于测试分片
+type Padding int
+
+func (p Padding) AddTo(m *Message) error {
+	m.Add(AttrPadding, make([]byte, int(p)))
+	return nil
+}
+
+func (p *Padding) GetFrom(m *Message) error {
+	v, err := m.Get(AttrPadding)
+	if err != nil {
+		return err
+	}
+	*p = Padding(len(v))
+	return nil
+}
+
+//RESPONSE-ORIGIN属性,响应的发送地址
+type ResponseOrigin MappedAddress
+
+func (a ResponseOrigin) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a ResponseOrigin) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrResponseOrigin)
+}
+
+func (a *ResponseOrigin) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrResponseOrigin)
+}
+
+//OTHER-ADDRESS属性,服务器另一个IP和端口的地址,取代RFC 3489的CHANGED-ADDRESS
+type OtherAddress MappedAddress
+
+func (a OtherAddress) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a OtherAddress) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrOtherAddress)
+}
+
+func (a *OtherAddress) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrOtherAddress)
+}
@@ -0,0 +1,63 @@
+package stun
+
+//RFC 3489使用的地址属性,格式与MAPPED-ADDRESS相同
+
+//SOURCE-ADDRESS属性,响应的发送地址
+type SourceAddress MappedAddress
+
+func (a SourceAddress) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a SourceAddress) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrSourceAddress)
+}
+
+func (a *SourceAddress) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrSourceAddress)
+}
+
+//CHANGED-ADDRESS属性,服务器另一个IP和端口的地址
+type ChangedAddress MappedAddress
+
+func (a ChangedAddress) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a ChangedAddress) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrChangedAddress)
+}
+
+func (a *ChangedAddress) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrChangedAddress)
+}
+
+//RESPONSE-ADDRESS属性,要求服务器把响应发到该地址
+type ResponseAddress MappedAddress
+
+func (a ResponseAddress) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a ResponseAddress) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrResponseAddress)
+}
+
+func (a *ResponseAddress) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrResponseAddress)
+}
+
+//REFLECTED-FROM属性,使用RESPONSE-ADDRESS时为请求的来源地址
+type ReflectedFrom MappedAddress
+
+func (a ReflectedFrom) String() string {
+	return MappedAddress(a).String()
+}
+
+func (a ReflectedFrom) AddTo(m *Message) error {
+	return MappedAddress(a).AddToAs(m, AttrReflectedFrom)
+}
+
+func (a *ReflectedFrom) GetFrom(m *Message) error {
+	return (*MappedAddress)(a).GetFromAs(m, AttrReflectedFrom)
+}
@@ -5,83 +5,192 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	ErrClientClosed = errors.New("client is closed")
+	ErrClientClosed     = errors.New("client is closed")
+	ErrMessageTruncated = errors.New("message is truncated")
 )
 
 func Dial(network string, addr *net.UDPAddr) (*Client, error) {
-	if addr == nil {
-		return nil, fmt.Errorf("server address is nil")
-	}
+	return DialWithOptions(network, addr, DialOptions{})
+}
 
-	// conn, err := net.Dial(network, address)
-	conn, err := net.ListenUDP(network, nil)
-	if err != nil {
-		return nil, err
-	}
+type ClientOptions struct {
+	Agent      ClientAgent
+	Connection net.PacketConn
+	ServerAddr net.Addr
 
-	cli := NewClient(conn, addr)
-	return cli, nil
-}
+	//默认100ms
+	TimeoutRate time.Duration
+
+	//非STUN数据(ChannelData、DTLS、RTP等)回调,为空时可通过PacketConn()读取
+	Handler PacketHandler
+
+	//响应来源地址校验策略,默认带CHANGE-REQUEST的请求不校验来源
+	ResponseAddrPolicy ResponseAddrPolicy
+
+	//时钟,默认SystemClock
+	Clock Clock
+
+	//重传初始超时时间,每次重传翻倍,为0时不重传
+	RTO time.Duration
+	//最多发送次数,默认7次(RFC 5389 Rc)
+	MaxTransmissions int
 
-// type ClientOptions struct {
-// 	Agent      ClientAgent
-// 	Connection Connection
+	//事务观察者,使用默认Agent时生效
+	Observer Observer
 
-// 	//默认100ms
-// 	TimeoutRate time.Duration
-// }
+	//最大并发事务数,使用默认Agent时生效,为0时不限制
+	MaxTransactions int
+	//每个目的地址的最大并发事务数,为0时不限制
+	MaxTransactionsPerDestination int
 
-// type Connection interface {
-// 	io.Reader
-// 	io.Writer
-// 	io.Closer
-// }
+	//接收缓冲区大小,默认为UDP最大长度65535
+	ReadBufferSize int
+
+	//长期凭证,设置后收到401/438时自动带上USERNAME、REALM、NONCE和MESSAGE-INTEGRITY重发请求
+	Username string
+	Password string
+
+	//新事务之间的最小间隔,为0时不限制,ICE等场景可使用DefaultPace(RFC 8445 Ta);
+	//启用后事务排队发送,排队时超过截止时间的事务以ErrTransactionTimeOut结束,
+	//发送失败通过回调通知
+	Pace time.Duration
+	//发往同一地址的新事务之间的最小间隔,为0时不限制
+	PacePerDestination time.Duration
+
+	//批量收发的数据报数量,大于1时Linux上的UDP连接使用recvmmsg/sendmmsg,
+	//每个数据报占用ReadBufferSize大小的缓冲区
+	BatchSize int
+}
 
 //新建客户端
 func NewClient(conn net.PacketConn, addr net.Addr) *Client {
+	return NewClientWithOptions(ClientOptions{
+		Connection: conn,
+		ServerAddr: addr,
+	})
+}
+
+//根据选项新建客户端
+func NewClientWithOptions(options ClientOptions) *Client {
 	c := &Client{
-		close: make(chan struct{}),
-		// c:      options.Connection,
-		// a:      options.Agent,
-		// gcRate: options.TimeoutRate,
+		close:   make(chan struct{}),
+		a:       options.Agent,
+		gcRate:  options.TimeoutRate,
+		handler: options.Handler,
+		policy:  options.ResponseAddrPolicy,
+		t:       make(map[transactionID]*clientTransaction),
+		clock:   options.Clock,
+		rto:     options.RTO,
+		maxTx:   options.MaxTransmissions,
+		stats:   newClientStats(),
+		maxDest: options.MaxTransactionsPerDestination,
+		dest:    make(map[string]int),
+		bufSize: options.ReadBufferSize,
+
+		username: options.Username,
+		password: options.Password,
+		auth:     make(map[string]authState),
+
+		pacer: pacer{
+			pace:     options.Pace,
+			destPace: options.PacePerDestination,
+			lastDest: make(map[string]time.Time),
+		},
 
-		serConn:      conn,
-		serAddr:      addr,
-		localAddrStr: conn.LocalAddr().String(),
+		serConn:   options.Connection,
+		serAddr:   options.ServerAddr,
+		localAddr: options.Connection.LocalAddr(),
+	}
+	_, c.bound = c.serConn.(*streamConn)
+	if c.clock == nil {
+		c.clock = SystemClock
 	}
 	if c.a == nil {
-		c.a = NewAgent(AgentOptions{})
+		c.a = NewAgent(AgentOptions{
+			Clock:           c.clock,
+			Observer:        options.Observer,
+			MaxTransactions: options.MaxTransactions,
+		})
+	}
+	if c.bufSize <= 0 {
+		c.bufSize = maxPacketSize
+	}
+	if c.maxTx == 0 {
+		c.maxTx = defaultMaxTransmissions
 	}
 	if c.gcRate == 0 {
 		c.gcRate = defaultTimeoutRate
 	}
-	fmt.Println("local:", c.localAddrStr)
+	if options.BatchSize > 1 {
+		c.batchSize = options.BatchSize
+		c.batch = NewBatchConn(c.serConn)
+	} else {
+		c.batch = NewPacketBatchConn(c.serConn)
+	}
+	fmt.Println("local:", c.localAddr)
 	c.wg.Add(2)
-	go c.readUntilClosed()
-	go c.collectUntilClosed()
+	if c.batchSize > 1 {
+		go c.readBatchUntilClosed()
+	} else {
+		go c.readUntilClosed()
+	}
+	//在启动协程前创建定时器,保证假时钟前进时定时器已注册
+	go c.collectUntilClosed(c.clock.NewTicker(c.gcRate))
 	return c
 }
 
 type Client struct {
-	a ClientAgent
-	// c            Connection
-	close        chan struct{}
-	closed       bool
-	closedMux    sync.RWMutex
-	gcRate       time.Duration
-	wg           sync.WaitGroup
-	localAddrStr string
+	a         ClientAgent
+	close     chan struct{}
+	closed    bool
+	closedMux sync.RWMutex
+	gcRate    time.Duration
+	wg        sync.WaitGroup
+	localAddr net.Addr
+
+	handler  PacketHandler
+	pconn    *clientPacketConn
+	pconnMux sync.Mutex // protects pconn
+
+	policy ResponseAddrPolicy
+	t      map[transactionID]*clientTransaction
+	tMux   sync.Mutex // protects t
+	clock  Clock
+	rto    time.Duration
+	maxTx  int
+	stats  *clientStats
+
+	maxDest int
+	dest    map[string]int // in-flight transactions per destination, protected by tMux
+	bufSize int
 
 	serConn net.PacketConn
+	bound   bool // 连接已绑定服务器(TCP/TLS),不校验响应来源
 	serAddr net.Addr
+	servers []net.Addr // failover addresses
+	addrMux sync.Mutex // protects serAddr and servers
+
+	username string
+	password string
+	auth     map[string]authState // realm and nonce per server
+	authMux  sync.Mutex           // protects auth
+
+	pacer   pacer
+	paceMux sync.Mutex // protects pacer
+
+	batch     BatchConn
+	batchSize int
 }
 
-func (c *Client) LocalAddr() string {
-	return c.localAddrStr
+//本地地址,非UDP连接时返回nil
+func (c *Client) LocalAddr() *net.UDPAddr {
+	addr, _ := c.localAddr.(*net.UDPAddr)
+	return addr
 }
 
 func (c *Client) ChangeServerAddr(addr string) error {
@@ -89,14 +198,76 @@ func (c *Client) ChangeServerAddr(addr string) error {
 	if err != nil {
 		return err
 	}
+	c.addrMux.Lock()
 	c.serAddr = serverUDPAddr
+	c.addrMux.Unlock()
 	return nil
 }
 
+//设置服务器地址列表,当前服务器的事务超时后切换到下一个地址
+func (c *Client) SetServers(addrs []net.Addr) {
+	if len(addrs) == 0 {
+		return
+	}
+	c.addrMux.Lock()
+	c.servers = append([]net.Addr(nil), addrs...)
+	c.serAddr = c.servers[0]
+	c.addrMux.Unlock()
+}
+
+//当前服务器地址
+func (c *Client) ServerAddr() net.Addr {
+	c.addrMux.Lock()
+	defer c.addrMux.Unlock()
+	return c.serAddr
+}
+
+//addr上的事务超时,切换到下一个服务器
+func (c *Client) failover(addr net.Addr) {
+	c.addrMux.Lock()
+	defer c.addrMux.Unlock()
+	if len(c.servers) < 2 || !sameAddr(c.serAddr, addr) {
+		return
+	}
+	for i, s := range c.servers {
+		if sameAddr(s, addr) {
+			c.serAddr = c.servers[(i+1)%len(c.servers)]
+			return
+		}
+	}
+}
+
+//返回复用客户端套接字的net.PacketConn,用于打洞后在同一端口收发应用数据,
+//只能读到非STUN数据,设置了Handler时读不到任何数据
+func (c *Client) PacketConn() net.PacketConn {
+	c.pconnMux.Lock()
+	defer c.pconnMux.Unlock()
+	if c.pconn == nil {
+		c.pconn = newClientPacketConn(c)
+	}
+	return c.pconn
+}
+
+//分发非STUN数据
+func (c *Client) handlePacket(b []byte, addr net.Addr) {
+	if c.handler != nil {
+		c.handler(b, addr)
+		return
+	}
+	c.pconnMux.Lock()
+	p := c.pconn
+	c.pconnMux.Unlock()
+	if p != nil {
+		p.push(b, addr)
+	}
+}
+
 //读数据协程
 func (c *Client) readUntilClosed() {
 	defer c.wg.Done()
 
+	//多分配一个字节,读满说明数据报超过缓冲区大小被截断
+	rBuf := make([]byte, c.bufSize+1)
 	for {
 		select {
 		//关闭通知
@@ -105,36 +276,126 @@ func (c *Client) readUntilClosed() {
 		default:
 		}
 
-		var (
-			n   int
-			err error
-		)
 		//读数据
-		m := new(Message)
-		m.Raw = make([]byte, 1024)
-		tBuf := m.Raw[:cap(m.Raw)]
-		n, _, err = c.serConn.ReadFrom(tBuf)
-		if err == nil {
-			if err = m.Decode(); err != nil {
-				fmt.Println("decode fail,err:", err)
-			} else {
-				//数据处理
-				if pErr := c.a.Process(m); pErr == ErrAgentClosed {
-					return
-				}
+		n, addr, err := c.serConn.ReadFrom(rBuf)
+		if err != nil {
+			if c.isClosed() {
+				return
 			}
-		} else {
 			if n == 0 {
 				fmt.Println("net close by peer")
 			}
 			fmt.Println("read invalid,", n)
+			continue
+		}
+		if !c.receive(rBuf, n, addr) {
+			return
 		}
 	}
 }
 
+//批量读数据协程
+func (c *Client) readBatchUntilClosed() {
+	defer c.wg.Done()
+
+	ms := make([]BatchMessage, c.batchSize)
+	for i := range ms {
+		ms[i].Buf = make([]byte, c.bufSize+1)
+	}
+	for {
+		select {
+		case <-c.close:
+			return
+		default:
+		}
+
+		n, err := c.batch.ReadBatch(ms)
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			fmt.Println("read invalid,", err)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if !c.receive(ms[i].Buf, ms[i].N, ms[i].Addr) {
+				return
+			}
+		}
+	}
+}
+
+//处理读到的n字节数据,rBuf比bufSize多一个字节,返回false时停止读取
+func (c *Client) receive(rBuf []byte, n int, addr net.Addr) bool {
+	truncated := n > c.bufSize
+	if truncated {
+		n = c.bufSize
+	}
+	buf := make([]byte, n)
+	copy(buf, rBuf)
+	kind := ClassifyPacket(buf)
+	if kind == PacketSTUN && messageHeaderSize+int(bin.Uint16(buf[2:4])) > n {
+		truncated = true
+	}
+	if truncated {
+		c.reportTruncated(buf, kind, addr)
+		return true
+	}
+	//非STUN数据交给应用层
+	if kind != PacketSTUN {
+		c.handlePacket(buf, addr)
+		return true
+	}
+	m := new(Message)
+	m.Raw = buf
+	if err := m.Decode(); err != nil {
+		atomic.AddInt64(&c.stats.decodeFailures, 1)
+		fmt.Println("decode fail,err:", err)
+		return true
+	}
+	//指纹不匹配说明是碰巧像STUN的应用数据
+	if err := m.CheckFingerprint(); err != nil {
+		c.handlePacket(buf, addr)
+		return true
+	}
+	//校验响应是否匹配事务的目的地址和方法
+	if err := c.checkResponse(m, addr); err != nil {
+		atomic.AddInt64(&c.stats.mismatches, 1)
+		return true
+	}
+	//数据处理
+	if pErr := c.a.Process(m); pErr == ErrAgentClosed {
+		return false
+	}
+	return true
+}
+
+//截断的STUN消息通知对应事务失败,其他数据以及来源或类型不匹配的消息直接丢弃
+func (c *Client) reportTruncated(b []byte, kind PacketKind, addr net.Addr) {
+	atomic.AddInt64(&c.stats.truncated, 1)
+	if kind != PacketSTUN {
+		return
+	}
+	var id transactionID
+	copy(id[:], b[8:messageHeaderSize])
+	var typ MessageType
+	typ.ReadValue(bin.Uint16(b[0:2]))
+	//只能校验头部,伪造的截断消息不应结束事务
+	if err := c.checkTruncated(id, typ, addr); err != nil {
+		atomic.AddInt64(&c.stats.mismatches, 1)
+		return
+	}
+	c.stopWithError(id, ErrMessageTruncated)
+}
+
+func (c *Client) isClosed() bool {
+	c.closedMux.RLock()
+	defer c.closedMux.RUnlock()
+	return c.closed
+}
+
 //定时检测事务超时
-func (c *Client) collectUntilClosed() {
-	t := time.NewTicker(c.gcRate)
+func (c *Client) collectUntilClosed(t Ticker) {
 	defer t.Stop()
 	defer c.wg.Done()
 
@@ -142,7 +403,7 @@ func (c *Client) collectUntilClosed() {
 		select {
 		case <-c.close:
 			return
-		case gcTime := <-t.C:
+		case gcTime := <-t.C():
 			err := c.a.Collect(gcTime)
 			if err != nil && err != ErrAgentClosed {
 				fmt.Println(err)
@@ -161,6 +422,7 @@ func (c *Client) Close() error {
 	}
 	c.closed = true
 	c.closedMux.Unlock()
+	c.stopPacing()
 	agentErr := c.a.Close()
 	connErr := c.serConn.Close()
 	close(c.close)
@@ -173,23 +435,117 @@ func (c *Client) Close() error {
 
 //启动发送事务
 func (c *Client) Start(m *Message, d time.Time, f func(AgentEvent)) error {
-	c.closedMux.RLock()
-	closed := c.closed
-	c.closedMux.RUnlock()
-	if closed {
+	return c.start(m, c.ServerAddr(), d, f, nil)
+}
+
+//向addr发送事务,prev为重定向或认证前的事务
+func (c *Client) start(m *Message, addr net.Addr, d time.Time, f AgentFn, prev *clientTransaction) error {
+	if c.isClosed() {
 		return ErrClientClosed
 	}
+	if f != nil && c.paced() {
+		return c.enqueue(m, addr, d, f, prev)
+	}
+	return c.send(m, addr, d, f, prev)
+}
+
+func (c *Client) send(m *Message, addr net.Addr, d time.Time, f AgentFn, prev *clientTransaction) error {
+	m, err := c.prepare(m, addr, d, f, prev)
+	if err != nil {
+		return err
+	}
+	_, err = c.serConn.WriteTo(m.Raw, addr)
+	return c.sent(m.TransactionID, f, err)
+}
+
+//登记事务,返回实际要发送的消息
+func (c *Client) prepare(m *Message, addr net.Addr, d time.Time, f AgentFn, prev *clientTransaction) (*Message, error) {
+	t := &clientTransaction{
+		addr:     addr,
+		req:      m.Raw,
+		deadline: d,
+		f:        f,
+	}
+	if prev != nil {
+		t.visited = prev.visited
+		t.authTries = prev.authTries
+	}
+	//已知服务器的realm和nonce时直接带上凭证
+	if s, ok := c.authState(addr); ok {
+		req := m.Raw
+		var err error
+		if m, err = c.authenticate(m.Raw, s); err != nil {
+			return nil, err
+		}
+		t.req = append([]byte(nil), req...)
+		t.key = NewLongTermIntegrity(c.username, s.realm, c.password)
+	}
 	if f != nil {
-		if err := c.a.Start(m.TransactionID, d, f); err != nil {
-			return err
+		if err := c.addTransaction(m, t); err != nil {
+			return nil, err
+		}
+		if err := c.a.Start(m.TransactionID, d, c.wrapTransaction(m.TransactionID, f)); err != nil {
+			c.removeTransaction(m.TransactionID)
+			return nil, err
 		}
 	}
-	_, err := c.serConn.WriteTo(m.Raw, c.serAddr)
-	if err != nil && f != nil {
+	return m, nil
+}
+
+//发送完成,失败时停止事务,成功时启动重传
+func (c *Client) sent(id transactionID, f AgentFn, err error) error {
+	if f == nil {
+		return err
+	}
+	if err != nil {
 		//发送失败，停止代理
-		if stopErr := c.a.Stop(m.TransactionID); stopErr != nil {
+		if stopErr := c.a.Stop(id); stopErr != nil {
 			return fmt.Errorf("stopErr:%v, Cause:%v", stopErr, err)
 		}
+		return err
+	}
+	c.scheduleRetransmit(id)
+	return nil
+}
+
+//批量启动发往当前服务器的事务,设置BatchSize时通过一次sendmmsg发送,
+//启用节奏控制时逐个排队;返回错误时未发送的事务已停止
+func (c *Client) StartBatch(ms []*Message, d time.Time, f AgentFn) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	addr := c.ServerAddr()
+	if f == nil || c.paced() {
+		for _, m := range ms {
+			if err := c.start(m, addr, d, f, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batch := make([]BatchMessage, 0, len(ms))
+	ids := make([]transactionID, 0, len(ms))
+	for _, m := range ms {
+		pm, err := c.prepare(m, addr, d, f, nil)
+		if err != nil {
+			for _, id := range ids {
+				c.a.Stop(id)
+			}
+			return err
+		}
+		batch = append(batch, BatchMessage{
+			Buf:  pm.Raw,
+			Addr: addr,
+		})
+		ids = append(ids, pm.TransactionID)
+	}
+	sent, err := c.batch.WriteBatch(batch)
+	for i, id := range ids {
+		if i < sent {
+			c.sent(id, f, nil)
+		} else {
+			c.sent(id, f, err)
+		}
 	}
 	return err
 }
@@ -231,3 +587,17 @@ type ClientAgent interface {
 	Stop(id [TransactionIDSize]byte) error
 	Collect(time.Time) error
 }
+
+//可以指定事务失败原因的ClientAgent,*Agent实现了该接口
+type errorStopper interface {
+	StopWithError(id [TransactionIDSize]byte, err error) error
+}
+
+//以err结束事务,agent不支持指定原因时用Stop结束
+func (c *Client) stopWithError(id transactionID, err error) {
+	if s, ok := c.a.(errorStopper); ok {
+		s.StopWithError(id, err)
+		return
+	}
+	c.a.Stop(id)
+}
@@ -0,0 +1,469 @@
+package stun_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+//读取一个请求,通过from回复一个指定类型的响应
+func respond(t *testing.T, server, from *net.UDPConn, typ stun.MessageType) {
+	t.Helper()
+	respondWith(t, server, from, func(req *stun.Message) *stun.Message {
+		res, err := stun.Build(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.TransactionID = req.TransactionID
+		res.WriteTransactionID()
+		return res
+	})
+}
+
+//读取一个请求,通过from回复build生成的响应
+func respondWith(t *testing.T, server, from *net.UDPConn, build func(req *stun.Message) *stun.Message) {
+	t.Helper()
+	buf := make([]byte, 1500)
+	if err := server.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	n, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &stun.Message{Raw: buf[:n]}
+	if err = req.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = from.WriteTo(build(req).Raw, addr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientResponseValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		policy    stun.ResponseAddrPolicy
+		alternate bool
+		typ       stun.MessageType
+		ok        bool
+	}{
+		{"same address", stun.ResponseAddrDefault, false, stun.BindingSuccess, true},
+		{"error response", stun.ResponseAddrDefault, false, stun.BindingError, true},
+		{"alternate address", stun.ResponseAddrDefault, true, stun.BindingSuccess, false},
+		{"alternate address allowed", stun.ResponseAddrAny, true, stun.BindingSuccess, true},
+		{"indication", stun.ResponseAddrDefault, false, stun.NewType(stun.MethodBinding, stun.ClassIndication), false},
+		{"other method", stun.ResponseAddrDefault, false, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := listenLoopback(t)
+			defer server.Close()
+			alternate := listenLoopback(t)
+			defer alternate.Close()
+			c := stun.NewClientWithOptions(stun.ClientOptions{
+				Connection:         listenLoopback(t),
+				ServerAddr:         server.LocalAddr(),
+				ResponseAddrPolicy: tc.policy,
+			})
+			defer c.Close()
+
+			done := make(chan stun.AgentEvent, 1)
+			m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+			if err := c.SendMessage(m, time.Now().Add(time.Millisecond*300), func(e stun.AgentEvent) {
+				done <- e
+			}); err != nil {
+				t.Fatal(err)
+			}
+			from := server
+			if tc.alternate {
+				from = alternate
+			}
+			respond(t, server, from, tc.typ)
+			e := <-done
+			if tc.ok && e.Error != nil {
+				t.Fatalf("unexpected error: %v", e.Error)
+			}
+			if !tc.ok && e.Error != stun.ErrTransactionTimeOut {
+				t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+			}
+			//被丢弃的响应计入统计
+			var want int64
+			if !tc.ok {
+				want = 1
+			}
+			if got := c.Stats().Mismatches; got != want {
+				t.Fatalf("got %d mismatches, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestClientTimeoutFakeClock(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, clock.Now().Add(time.Second*3), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	select {
+	case e := <-done:
+		t.Fatalf("unexpected event before deadline: %+v", e)
+	case <-time.After(time.Millisecond * 20):
+	}
+	clock.Advance(time.Second * 3)
+	select {
+	case e := <-done:
+		if e.Error != stun.ErrTransactionTimeOut {
+			t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("transaction is not timed out")
+	}
+}
+
+func TestClientRetransmit(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection:       listenLoopback(t),
+		ServerAddr:       server.LocalAddr(),
+		Clock:            clock,
+		RTO:              time.Millisecond * 500,
+		MaxTransmissions: 3,
+	})
+	defer c.Close()
+
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, clock.Now().Add(time.Minute), func(stun.AgentEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+	read := func() bool {
+		buf := make([]byte, 1500)
+		if err := server.SetReadDeadline(time.Now().Add(time.Millisecond * 200)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		if string(buf[:n]) != string(m.Raw) {
+			t.Fatalf("retransmitted %x, want %x", buf[:n], m.Raw)
+		}
+		return true
+	}
+	if !read() {
+		t.Fatal("no initial transmission")
+	}
+	// RTO 500ms, 1s: 3 transmissions in total.
+	for i, d := range []time.Duration{time.Millisecond * 500, time.Second} {
+		clock.Advance(d)
+		if !read() {
+			t.Fatalf("no retransmission %d", i+1)
+		}
+	}
+	clock.Advance(time.Second * 2)
+	if read() {
+		t.Fatal("unexpected retransmission over limit")
+	}
+}
+
+func TestClientEventTiming(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+		RTO:        time.Millisecond * 500,
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	start := clock.Now()
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, clock.Now().Add(time.Minute), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	if _, _, err := server.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Millisecond * 500)
+	clock.Advance(time.Millisecond * 200)
+	respond(t, server, server, stun.BindingSuccess)
+	e := <-done
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if !e.Sent.Equal(start) || !e.Received.Equal(start.Add(time.Millisecond*700)) {
+		t.Fatalf("sent %v, received %v", e.Sent, e.Received)
+	}
+	if e.RTT != time.Millisecond*700 || e.Transmissions != 2 {
+		t.Fatalf("rtt %v, transmissions %d", e.RTT, e.Transmissions)
+	}
+	if e.Remote == nil || e.Remote.String() != server.LocalAddr().String() {
+		t.Fatalf("remote %v, want %v", e.Remote, server.LocalAddr())
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 2)
+	f := func(e stun.AgentEvent) {
+		done <- e
+	}
+	deadline := clock.Now().Add(time.Second)
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, f); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.InFlight != 2 || s.Transactions != 2 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	respond(t, server, server, stun.BindingSuccess)
+	<-done
+	clock.Advance(time.Second * 2)
+	<-done
+
+	s := c.Stats()
+	if s.InFlight != 0 || s.Responses != 1 || s.Timeouts != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if h := s.RTT[server.LocalAddr().String()]; h.Count != 1 {
+		t.Fatalf("unexpected rtt histogram: %+v", s.RTT)
+	}
+}
+
+func TestClientPublishStats(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClient(listenLoopback(t), server.LocalAddr())
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	respond(t, server, server, stun.BindingSuccess)
+	if e := <-done; e.Error != nil {
+		t.Fatal(e.Error)
+	}
+
+	//同名变量只能发布一次,用-count多次运行时换名字
+	name := "stun_client_test_" + c.LocalAddr().String()
+	c.PublishStats(name)
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("stats not published")
+	}
+	var s stun.Stats
+	if err := json.Unmarshal([]byte(v.String()), &s); err != nil {
+		t.Fatalf("%v: %s", err, v.String())
+	}
+	if s.Transactions != 1 || s.Responses != 1 || s.InFlight != 0 {
+		t.Fatalf("unexpected stats: %s", v.String())
+	}
+	if h := s.RTT[server.LocalAddr().String()]; h.Count != 1 {
+		t.Fatalf("unexpected rtt histogram: %s", v.String())
+	}
+}
+
+func TestClientMaxTransactionsPerDestination(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	other := listenLoopback(t)
+	defer other.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection:                    listenLoopback(t),
+		ServerAddr:                    server.LocalAddr(),
+		MaxTransactionsPerDestination: 1,
+	})
+	defer c.Close()
+
+	nop := func(stun.AgentEvent) {}
+	deadline := time.Now().Add(time.Minute)
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, nop); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, nop); err != stun.ErrTooManyTransactions {
+		t.Fatalf("got %v, want %v", err, stun.ErrTooManyTransactions)
+	}
+	if err := c.ChangeServerAddr(other.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), deadline, nop); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.InFlight != 2 {
+		t.Fatalf("got %d in flight, want 2", s.InFlight)
+	}
+}
+
+func TestClientLargeMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		bufSize int
+		err     error
+	}{
+		{"default buffer", 0, nil},
+		{"small buffer", 512, stun.ErrMessageTruncated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := listenLoopback(t)
+			defer server.Close()
+			c := stun.NewClientWithOptions(stun.ClientOptions{
+				Connection:     listenLoopback(t),
+				ServerAddr:     server.LocalAddr(),
+				ReadBufferSize: tc.bufSize,
+			})
+			defer c.Close()
+
+			done := make(chan stun.AgentEvent, 1)
+			m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+			if err := c.SendMessage(m, time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+				done <- e
+			}); err != nil {
+				t.Fatal(err)
+			}
+			respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+				res := stun.MustBuild(stun.BindingSuccess)
+				res.TransactionID = req.TransactionID
+				res.WriteTransactionID()
+				res.Add(stun.AttrPadding, make([]byte, 4000))
+				return res
+			})
+			e := <-done
+			if e.Error != tc.err {
+				t.Fatalf("got %v, want %v", e.Error, tc.err)
+			}
+			if tc.err != nil && c.Stats().Truncated != 1 {
+				t.Fatalf("got %d truncated, want 1", c.Stats().Truncated)
+			}
+			if tc.err == nil && len(e.Message.Raw) != 4024 {
+				t.Fatalf("got %d bytes, want %d", len(e.Message.Raw), 4024)
+			}
+		})
+	}
+}
+
+func TestClientTruncatedFromOtherAddress(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	other := listenLoopback(t)
+	defer other.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.SendMessage(m, time.Now().Add(time.Millisecond*300), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	//头部长度大于实际数据的伪造响应
+	respondWith(t, server, other, func(req *stun.Message) *stun.Message {
+		res := stun.MustBuild(stun.BindingSuccess)
+		res.TransactionID = req.TransactionID
+		res.WriteTransactionID()
+		res.Raw[3] = 200
+		return res
+	})
+	if e := <-done; e.Error != stun.ErrTransactionTimeOut {
+		t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+	}
+	if s := c.Stats(); s.Truncated != 1 || s.Mismatches != 1 {
+		t.Fatalf("got %d truncated and %d mismatches, want 1 and 1", s.Truncated, s.Mismatches)
+	}
+}
+
+//只实现ClientAgent的自定义agent,没有StopWithError
+type plainAgent struct {
+	a *stun.Agent
+}
+
+func (p plainAgent) Process(m *stun.Message) error { return p.a.Process(m) }
+func (p plainAgent) Close() error                  { return p.a.Close() }
+func (p plainAgent) Collect(t time.Time) error     { return p.a.Collect(t) }
+func (p plainAgent) Stop(id [stun.TransactionIDSize]byte) error {
+	return p.a.Stop(id)
+}
+func (p plainAgent) Start(id [stun.TransactionIDSize]byte, d time.Time, f stun.AgentFn) error {
+	return p.a.Start(id, d, f)
+}
+
+func TestClientCustomAgentTruncated(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection:     listenLoopback(t),
+		ServerAddr:     server.LocalAddr(),
+		ReadBufferSize: 512,
+		Agent:          plainAgent{a: stun.NewAgent(stun.AgentOptions{})},
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	respondWith(t, server, server, func(req *stun.Message) *stun.Message {
+		res := stun.MustBuild(stun.BindingSuccess)
+		res.TransactionID = req.TransactionID
+		res.WriteTransactionID()
+		res.Add(stun.AttrPadding, make([]byte, 1000))
+		return res
+	})
+	//不支持指定原因时事务被停止
+	if e := <-done; e.Error != stun.ErrTransactionStopped {
+		t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionStopped)
+	}
+}
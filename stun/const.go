@@ -7,6 +7,13 @@ const (
 	magicCookie        = 0x2112A442 // magicCookie 固定值为0x2112A442
 	defaultTimeoutRate = time.Millisecond * 100
 
+	defaultMaxTransmissions = 7
+
+	maxPacketSize = 65535 // UDP最大长度
+
 	familyIPv4 uint16 = 0x01
 	familyIPv6 uint16 = 0x02
 )
+
+//RFC 5389消息头中的magic cookie,RFC 3489消息在该位置是事务id的前4字节
+const MagicCookie uint32 = magicCookie
@@ -0,0 +1,205 @@
+package stun
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//数据包类型,按RFC 7983首字节规则区分同一端口上的不同协议
+//
+//                 +----------------+
+//                 |        [0..3] -+--> forward to STUN
+//                 |                |
+//                 |      [16..19] -+--> forward to ZRTP
+//                 |                |
+//     packet -->  |      [20..63] -+--> forward to DTLS
+//                 |                |
+//                 |      [64..79] -+--> forward to TURN Channel
+//                 |                |
+//                 |    [128..191] -+--> forward to RTP/RTCP
+//                 +----------------+
+type PacketKind int
+
+const (
+	PacketUnknown PacketKind = iota
+	PacketSTUN
+	PacketZRTP
+	PacketDTLS
+	PacketChannelData
+	PacketRTP
+)
+
+var packetKindName = map[PacketKind]string{
+	PacketUnknown:     "unknown",
+	PacketSTUN:        "stun",
+	PacketZRTP:        "zrtp",
+	PacketDTLS:        "dtls",
+	PacketChannelData: "channel data",
+	PacketRTP:         "rtp",
+}
+
+func (k PacketKind) String() string {
+	return packetKindName[k]
+}
+
+//ChannelData头部长度,2字节通道号+2字节长度
+const channelDataHeaderSize = 4
+
+//根据首字节判断数据包类型,STUN还需要校验magic cookie
+func ClassifyPacket(b []byte) PacketKind {
+	if len(b) == 0 {
+		return PacketUnknown
+	}
+	switch f := b[0]; {
+	case f <= 3:
+		if IsMessage(b) {
+			return PacketSTUN
+		}
+	case f >= 16 && f <= 19:
+		return PacketZRTP
+	case f >= 20 && f <= 63:
+		return PacketDTLS
+	case f >= 64 && f <= 79:
+		if len(b) >= channelDataHeaderSize {
+			return PacketChannelData
+		}
+	case f >= 128 && f <= 191:
+		return PacketRTP
+	}
+	return PacketUnknown
+}
+
+//判断是否为STUN消息:头部最高两位为0,长度4字节对齐,cookie为固定值
+func IsMessage(b []byte) bool {
+	if len(b) < messageHeaderSize {
+		return false
+	}
+	if b[0]&0xc0 != 0 {
+		return false
+	}
+	if bin.Uint16(b[2:4])%padding != 0 {
+		return false
+	}
+	return bin.Uint32(b[4:8]) == magicCookie
+}
+
+//非STUN数据回调,b的所有权交给回调
+type PacketHandler func(b []byte, addr net.Addr)
+
+//非STUN数据读取队列长度,队列满时丢弃
+const packetQueueSize = 64
+
+type packet struct {
+	b    []byte
+	addr net.Addr
+}
+
+//复用Client套接字的net.PacketConn,只读出非STUN数据,写入直接走Client套接字
+type clientPacketConn struct {
+	c         *Client
+	queue     chan packet
+	done      chan struct{}
+	closeOnce sync.Once
+	mux       sync.Mutex // protects deadline and changed
+	deadline  time.Time
+	changed   chan struct{} // 修改读超时时关闭,唤醒阻塞的ReadFrom
+}
+
+func newClientPacketConn(c *Client) *clientPacketConn {
+	return &clientPacketConn{
+		c:       c,
+		queue:   make(chan packet, packetQueueSize),
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+//投递数据到读取队列
+func (p *clientPacketConn) push(b []byte, addr net.Addr) {
+	select {
+	case p.queue <- packet{b: b, addr: addr}:
+	default:
+	}
+}
+
+//读超时对阻塞中的ReadFrom同样生效
+func (p *clientPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		p.mux.Lock()
+		d, changed := p.deadline, p.changed
+		p.mux.Unlock()
+		var timeout <-chan time.Time
+		var t *time.Timer
+		if !d.IsZero() {
+			t = time.NewTimer(time.Until(d))
+			timeout = t.C
+		}
+		select {
+		case pkt := <-p.queue:
+			stopTimer(t)
+			return copy(b, pkt.b), pkt.addr, nil
+		case <-p.done:
+			stopTimer(t)
+			return 0, nil, ErrClientClosed
+		case <-p.c.close:
+			stopTimer(t)
+			return 0, nil, ErrClientClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-changed:
+			stopTimer(t)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+func (p *clientPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-p.done:
+		return 0, ErrClientClosed
+	default:
+	}
+	return p.c.serConn.WriteTo(b, addr)
+}
+
+//只关闭包装层,底层套接字由Client负责关闭,之后PacketConn返回新的包装
+func (p *clientPacketConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.c.pconnMux.Lock()
+		if p.c.pconn == p {
+			p.c.pconn = nil
+		}
+		p.c.pconnMux.Unlock()
+	})
+	return nil
+}
+
+func (p *clientPacketConn) LocalAddr() net.Addr {
+	return p.c.serConn.LocalAddr()
+}
+
+func (p *clientPacketConn) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *clientPacketConn) SetReadDeadline(t time.Time) error {
+	p.mux.Lock()
+	p.deadline = t
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mux.Unlock()
+	return nil
+}
+
+//写入不会阻塞,忽略写超时
+func (p *clientPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
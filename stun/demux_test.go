@@ -0,0 +1,127 @@
+package stun_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+func TestClassifyPacket(t *testing.T) {
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		kind stun.PacketKind
+	}{
+		{"stun", m.Raw, stun.PacketSTUN},
+		{"stun without cookie", make([]byte, 20), stun.PacketUnknown},
+		{"zrtp", []byte{16, 0, 0, 0}, stun.PacketZRTP},
+		{"dtls", []byte{22, 254, 253, 0}, stun.PacketDTLS},
+		{"channel data", []byte{0x40, 0x00, 0x00, 0x00}, stun.PacketChannelData},
+		{"rtp", []byte{0x80, 0x60, 0x00, 0x01}, stun.PacketRTP},
+		{"empty", nil, stun.PacketUnknown},
+	} {
+		if kind := stun.ClassifyPacket(tc.b); kind != tc.kind {
+			t.Errorf("%s: got %s, want %s", tc.name, kind, tc.kind)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	m.AddSoftwareAttribute("test")
+	m.AddFingerprintAttribute()
+
+	d := new(stun.Message)
+	d.Raw = append([]byte(nil), m.Raw...)
+	if err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CheckFingerprint(); err != nil {
+		t.Fatal(err)
+	}
+	d.Raw[len(d.Raw)-1] ^= 0xff
+	if err := d.CheckFingerprint(); err != stun.ErrFingerprintMismatch {
+		t.Fatalf("got %v, want %v", err, stun.ErrFingerprintMismatch)
+	}
+}
+
+func TestClientPacketConn(t *testing.T) {
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stun.NewClient(conn, peer.LocalAddr())
+	defer c.Close()
+
+	pc := c.PacketConn()
+	data := []byte{0x80, 0x60, 0x00, 0x01, 'r', 't', 'p'}
+	if _, err = peer.WriteTo(data, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err = pc.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != string(data) {
+		t.Errorf("got %x, want %x", buf[:n], data)
+	}
+	if addr.String() != peer.LocalAddr().String() {
+		t.Errorf("got addr %s, want %s", addr, peer.LocalAddr())
+	}
+}
+
+func TestClientPacketConnDeadline(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stun.NewClient(conn, conn.LocalAddr())
+	defer c.Close()
+
+	//阻塞中的ReadFrom在设置读超时后返回
+	pc := c.PacketConn()
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 64))
+		read <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	if err = pc.SetReadDeadline(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-read:
+		if err != os.ErrDeadlineExceeded {
+			t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrom not woken by SetReadDeadline")
+	}
+
+	//关闭后重新获取的包装可以正常使用
+	pc.Close()
+	pc2 := c.PacketConn()
+	if pc2 == pc {
+		t.Fatal("closed PacketConn returned again")
+	}
+	if _, err = pc2.WriteTo([]byte{0x80, 0x60}, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	pc2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if n, _, err := pc2.ReadFrom(make([]byte, 64)); err != nil || n != 2 {
+		t.Fatalf("got %d bytes, %v", n, err)
+	}
+}
@@ -0,0 +1,77 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var (
+	ErrSockoptUnsupported = errors.New("socket option is not supported on this platform")
+)
+
+//拨号选项
+type DialOptions struct {
+	//绑定的本地地址,为空时由系统分配
+	LocalAddr *net.UDPAddr
+
+	//SO_REUSEADDR
+	ReuseAddr bool
+	//SO_REUSEPORT
+	ReusePort bool
+	//IP_TOS/IPV6_TCLASS,DSCP值需要左移2位,为0时不设置
+	TOS int
+	//设置DF位,禁止分片
+	DontFragment bool
+
+	//客户端选项,Connection和ServerAddr由拨号填充
+	Client ClientOptions
+}
+
+func (o DialOptions) hasSockopts() bool {
+	return o.ReuseAddr || o.ReusePort || o.TOS != 0 || o.DontFragment
+}
+
+//创建UDP套接字并新建客户端,network为udp、udp4或udp6
+func DialWithOptions(network string, addr *net.UDPAddr, o DialOptions) (*Client, error) {
+	if addr == nil {
+		return nil, fmt.Errorf("server address is nil")
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	lc := net.ListenConfig{}
+	if o.hasSockopts() {
+		lc.Control = o.control
+	}
+	laddr := ""
+	if o.LocalAddr != nil {
+		laddr = o.LocalAddr.String()
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	options := o.Client
+	options.Connection = conn
+	options.ServerAddr = addr
+	return NewClientWithOptions(options), nil
+}
+
+//在bind之前设置套接字选项
+func (o DialOptions) control(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = setSockopts(fd, network, o)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,38 @@
+package stun_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+func TestDialWithOptions(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	o := stun.DialOptions{
+		LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+	}
+	if runtime.GOOS == "linux" {
+		o.ReuseAddr = true
+		o.ReusePort = true
+		o.TOS = 46 << 2 // EF
+		o.DontFragment = true
+	}
+	c, err := stun.DialWithOptions("udp4", server.LocalAddr().(*net.UDPAddr), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	la := c.LocalAddr()
+	if la == nil || !la.IP.Equal(o.LocalAddr.IP) || la.Port == 0 {
+		t.Fatalf("unexpected local address %v", la)
+	}
+}
+
+func TestDialUnknownNetwork(t *testing.T) {
+	if _, err := stun.DialWithOptions("tcp", &net.UDPAddr{}, stun.DialOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,114 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadErrorCode = errors.New("bad error code attribute")
+)
+
+//错误码
+type ErrorCode int
+
+const (
+	CodeTryAlternate     ErrorCode = 300
+	CodeBadRequest       ErrorCode = 400
+	CodeUnauthorized     ErrorCode = 401
+	CodeUnknownAttribute ErrorCode = 420
+	CodeStaleNonce       ErrorCode = 438
+	CodeServerError      ErrorCode = 500
+)
+
+var errorReasons = map[ErrorCode]string{
+	CodeTryAlternate:     "Try Alternate",
+	CodeBadRequest:       "Bad Request",
+	CodeUnauthorized:     "Unauthorized",
+	CodeUnknownAttribute: "Unknown Attribute",
+	CodeStaleNonce:       "Stale Nonce",
+	CodeServerError:      "Server Error",
+}
+
+//添加默认原因短语的错误码属性
+func (c ErrorCode) AddTo(m *Message) error {
+	return ErrorCodeAttribute{
+		Code:   c,
+		Reason: errorReasons[c],
+	}.AddTo(m)
+}
+
+//ERROR-CODE属性
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|           Reserved, should be 0         |Class|     Number    |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|      Reason Phrase (variable)                                ..
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type ErrorCodeAttribute struct {
+	Code   ErrorCode
+	Reason string
+}
+
+func (a ErrorCodeAttribute) String() string {
+	return fmt.Sprintf("%d: %s", a.Code, a.Reason)
+}
+
+func (a ErrorCodeAttribute) AddTo(m *Message) error {
+	if a.Code < 300 || a.Code > 699 {
+		return ErrBadErrorCode
+	}
+	value := make([]byte, 4+len(a.Reason))
+	value[2] = byte(a.Code / 100)
+	value[3] = byte(a.Code % 100)
+	copy(value[4:], a.Reason)
+	m.Add(AttrErrorCode, value)
+	return nil
+}
+
+func (a *ErrorCodeAttribute) GetFrom(m *Message) error {
+	v, err := m.Get(AttrErrorCode)
+	if err != nil {
+		return err
+	}
+	if len(v) < 4 {
+		return ErrBadErrorCode
+	}
+	class := int(v[2] & 0x07)
+	number := int(v[3])
+	if class < 3 || class > 6 || number > 99 {
+		return ErrBadErrorCode
+	}
+	a.Code = ErrorCode(class*100 + number)
+	a.Reason = string(v[4:])
+	return nil
+}
+
+//UNKNOWN-ATTRIBUTES属性,420响应中列出不认识的必须理解属性
+type UnknownAttributes []AttrType
+
+func (a UnknownAttributes) AddTo(m *Message) error {
+	value := make([]byte, 2*len(a))
+	for i, t := range a {
+		bin.PutUint16(value[2*i:], t.Value())
+	}
+	m.Add(AttrUnknownAttributes, value)
+	return nil
+}
+
+func (a *UnknownAttributes) GetFrom(m *Message) error {
+	v, err := m.Get(AttrUnknownAttributes)
+	if err != nil {
+		return err
+	}
+	if len(v)%2 != 0 {
+		return ErrBadErrorCode
+	}
+	*a = (*a)[:0]
+	for i := 0; i < len(v); i += 2 {
+		*a = append(*a, AttrType(bin.Uint16(v[i:])))
+	}
+	return nil
+}
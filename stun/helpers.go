@@ -31,3 +31,43 @@ type transactionIDSetter struct{}
 func (transactionIDSetter) AddTo(m *Message) error {
 	return m.NewTransactionID()
 }
+
+//SOFTWARE属性
+type Software string
+
+func (s Software) AddTo(m *Message) error {
+	m.AddSoftwareAttribute(string(s))
+	return nil
+}
+
+func (s *Software) GetFrom(m *Message) error {
+	v, err := m.Get(AttrSoftware)
+	if err != nil {
+		return err
+	}
+	*s = Software(v)
+	return nil
+}
+
+//添加FINGERPRINT属性,必须是最后一个Setter
+var Fingerprint Setter = fingerprintSetter{}
+
+type fingerprintSetter struct{}
+
+func (fingerprintSetter) AddTo(m *Message) error {
+	m.AddFingerprintAttribute()
+	return nil
+}
+
+//设置指定的事务id,用于构造与请求对应的响应,需在MESSAGE-INTEGRITY之前
+func NewTransactionIDSetter(id [TransactionIDSize]byte) Setter {
+	return transactionIDValue(id)
+}
+
+type transactionIDValue [TransactionIDSize]byte
+
+func (id transactionIDValue) AddTo(m *Message) error {
+	m.TransactionID = id
+	m.WriteTransactionID()
+	return nil
+}
@@ -0,0 +1,138 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrIntegrityMismatch = errors.New("message integrity check failed")
+)
+
+//MESSAGE-INTEGRITY属性值长度
+const messageIntegritySize = sha1.Size
+
+//MESSAGE-INTEGRITY属性,值为HMAC-SHA1的key(RFC 5389 15.4)
+type MessageIntegrity []byte
+
+//长期凭证的key: MD5(username ":" realm ":" password)
+func NewLongTermIntegrity(username, realm, password string) MessageIntegrity {
+	k := strings.Join([]string{username, realm, password}, ":")
+	h := md5.Sum([]byte(k))
+	return MessageIntegrity(h[:])
+}
+
+//短期凭证的key即密码
+func NewShortTermIntegrity(password string) MessageIntegrity {
+	return MessageIntegrity(password)
+}
+
+//添加MESSAGE-INTEGRITY属性,必须在FINGERPRINT之前添加,
+//HMAC计算时头部长度需包含该属性本身
+func (i MessageIntegrity) AddTo(m *Message) error {
+	l := m.Length
+	m.Length += attributeHeaderSize + messageIntegritySize
+	m.WriteLength()
+	v := i.sum(m.Raw[:messageHeaderSize+int(l)])
+	m.Length = l
+	m.Add(AttrMessageIntegrity, v)
+	return nil
+}
+
+//校验MESSAGE-INTEGRITY属性,其后只允许FINGERPRINT属性
+func (i MessageIntegrity) Check(m *Message) error {
+	offset, ok := attrOffset(m.Raw, AttrMessageIntegrity)
+	if !ok {
+		return ErrAttributeNotFound
+	}
+	end := offset + attributeHeaderSize + messageIntegritySize
+	if end > len(m.Raw) || int(bin.Uint16(m.Raw[offset+2:offset+4])) != messageIntegritySize {
+		return ErrIntegrityMismatch
+	}
+	//头部长度改为到MESSAGE-INTEGRITY为止
+	b := make([]byte, offset)
+	copy(b, m.Raw[:offset])
+	bin.PutUint16(b[2:4], uint16(end-messageHeaderSize))
+	if !hmac.Equal(i.sum(b), m.Raw[offset+attributeHeaderSize:end]) {
+		return ErrIntegrityMismatch
+	}
+	return nil
+}
+
+func (i MessageIntegrity) sum(b []byte) []byte {
+	h := hmac.New(sha1.New, i)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+//属性t在原始数据中的偏移
+func attrOffset(raw []byte, t AttrType) (int, bool) {
+	if len(raw) < messageHeaderSize {
+		return 0, false
+	}
+	end := messageHeaderSize + int(bin.Uint16(raw[2:4]))
+	if end > len(raw) {
+		return 0, false
+	}
+	for offset := messageHeaderSize; offset+attributeHeaderSize <= end; {
+		if AttrType(bin.Uint16(raw[offset:offset+2])) == t {
+			return offset, true
+		}
+		offset += attributeHeaderSize + nearestPaddedValueLength(int(bin.Uint16(raw[offset+2:offset+4])))
+	}
+	return 0, false
+}
+
+//USERNAME属性
+type Username string
+
+func (u Username) AddTo(m *Message) error {
+	m.Add(AttrUsername, []byte(u))
+	return nil
+}
+
+func (u *Username) GetFrom(m *Message) error {
+	v, err := m.Get(AttrUsername)
+	if err != nil {
+		return err
+	}
+	*u = Username(v)
+	return nil
+}
+
+//REALM属性
+type Realm string
+
+func (r Realm) AddTo(m *Message) error {
+	m.Add(AttrRealm, []byte(r))
+	return nil
+}
+
+func (r *Realm) GetFrom(m *Message) error {
+	v, err := m.Get(AttrRealm)
+	if err != nil {
+		return err
+	}
+	*r = Realm(v)
+	return nil
+}
+
+//NONCE属性
+type Nonce string
+
+func (n Nonce) AddTo(m *Message) error {
+	m.Add(AttrNonce, []byte(n))
+	return nil
+}
+
+func (n *Nonce) GetFrom(m *Message) error {
+	v, err := m.Get(AttrNonce)
+	if err != nil {
+		return err
+	}
+	*n = Nonce(v)
+	return nil
+}
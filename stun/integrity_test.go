@@ -0,0 +1,71 @@
+package stun_test
+
+import (
+	"testing"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//RFC 5769 2.1 Sample Request
+var sampleRequest = []byte{
+	0x00, 0x01, 0x00, 0x58,
+	0x21, 0x12, 0xa4, 0x42,
+	0xb7, 0xe7, 0xa7, 0x01, 0xbc, 0x34, 0xd6, 0x86, 0xfa, 0x87, 0xdf, 0xae,
+	0x80, 0x22, 0x00, 0x10,
+	0x53, 0x54, 0x55, 0x4e, 0x20, 0x74, 0x65, 0x73, 0x74, 0x20, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74,
+	0x00, 0x24, 0x00, 0x04,
+	0x6e, 0x00, 0x01, 0xff,
+	0x80, 0x29, 0x00, 0x08,
+	0x93, 0x2f, 0xf9, 0xb1, 0x51, 0x26, 0x3b, 0x36,
+	0x00, 0x06, 0x00, 0x09,
+	0x65, 0x76, 0x74, 0x6a, 0x3a, 0x68, 0x36, 0x76, 0x59, 0x20, 0x20, 0x20,
+	0x00, 0x08, 0x00, 0x14,
+	0x9a, 0xea, 0xa7, 0x0c, 0xbf, 0xd8, 0xcb, 0x56, 0x78, 0x1e,
+	0xf2, 0xb5, 0xb2, 0xd3, 0xf2, 0x49, 0xc1, 0xb5, 0x71, 0xa2,
+	0x80, 0x28, 0x00, 0x04,
+	0xe5, 0x7a, 0x3b, 0xcf,
+}
+
+func TestMessageIntegrityCheck(t *testing.T) {
+	m := &stun.Message{Raw: append([]byte(nil), sampleRequest...)}
+	if err := m.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckFingerprint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stun.NewShortTermIntegrity("VOkJxbRl1RmTxUk/WvJxBt").Check(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := stun.NewShortTermIntegrity("wrong").Check(m); err != stun.ErrIntegrityMismatch {
+		t.Fatalf("got %v, want %v", err, stun.ErrIntegrityMismatch)
+	}
+	var u stun.Username
+	if err := u.GetFrom(m); err != nil || u != "evtj:h6vY" {
+		t.Fatalf("username %q, %v", u, err)
+	}
+}
+
+func TestMessageIntegrityAddTo(t *testing.T) {
+	key := stun.NewLongTermIntegrity("user", "realm", "pass")
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest,
+		stun.Username("user"), stun.Realm("realm"), stun.Nonce("nonce"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddFingerprintAttribute()
+
+	d := &stun.Message{Raw: m.Raw}
+	if err = d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if err = key.Check(d); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.CheckFingerprint(); err != nil {
+		t.Fatal(err)
+	}
+	if err = stun.NewLongTermIntegrity("user", "realm", "other").Check(d); err != stun.ErrIntegrityMismatch {
+		t.Fatalf("got %v, want %v", err, stun.ErrIntegrityMismatch)
+	}
+}
@@ -0,0 +1,20 @@
+package stun
+
+import "time"
+
+// 事务观察者,回调在事务回调之前调用,不能阻塞
+type Observer interface {
+	TransactionStarted(id [TransactionIDSize]byte, deadline time.Time)
+	TransactionResponded(id [TransactionIDSize]byte, m *Message, rtt time.Duration)
+	TransactionTimedOut(id [TransactionIDSize]byte)
+	TransactionStopped(id [TransactionIDSize]byte, err error)
+	AgentClosed()
+}
+
+type nopObserver struct{}
+
+func (nopObserver) TransactionStarted([TransactionIDSize]byte, time.Time)                 {}
+func (nopObserver) TransactionResponded([TransactionIDSize]byte, *Message, time.Duration) {}
+func (nopObserver) TransactionTimedOut([TransactionIDSize]byte)                           {}
+func (nopObserver) TransactionStopped([TransactionIDSize]byte, error)                     {}
+func (nopObserver) AgentClosed()                                                          {}
@@ -0,0 +1,161 @@
+package stun
+
+import (
+	"net"
+	"time"
+)
+
+//RFC 8445 14.2建议的连通性检查间隔Ta
+const DefaultPace = time.Millisecond * 50
+
+//等待发送的事务
+type pacedRequest struct {
+	m    *Message
+	addr net.Addr
+	d    time.Time
+	f    AgentFn
+	prev *clientTransaction
+}
+
+//事务发送节奏控制,两个新事务之间至少间隔pace,发往同一地址的新事务至少间隔destPace
+type pacer struct {
+	pace     time.Duration
+	destPace time.Duration
+	last     time.Time
+	lastDest map[string]time.Time
+	queue    []*pacedRequest
+	timer    Timer
+	closed   bool
+}
+
+func (c *Client) paced() bool {
+	return c.pacer.pace > 0 || c.pacer.destPace > 0
+}
+
+//事务排队,按间隔依次发送,发送失败或排队时已过期通过f通知
+func (c *Client) enqueue(m *Message, addr net.Addr, d time.Time, f AgentFn, prev *clientTransaction) error {
+	//调用方可能复用消息,排队时保存副本
+	req := &Message{Raw: append([]byte(nil), m.Raw...)}
+	if err := req.Decode(); err != nil {
+		return err
+	}
+	c.paceMux.Lock()
+	if c.pacer.closed {
+		c.paceMux.Unlock()
+		return ErrClientClosed
+	}
+	c.pacer.queue = append(c.pacer.queue, &pacedRequest{
+		m:    req,
+		addr: addr,
+		d:    d,
+		f:    f,
+		prev: prev,
+	})
+	c.paceMux.Unlock()
+	c.drain()
+	return nil
+}
+
+//发送到期的事务,并设置下一次发送的定时器
+func (c *Client) drain() {
+	var expired, ready []*pacedRequest
+	c.paceMux.Lock()
+	p := &c.pacer
+	if p.closed {
+		c.paceMux.Unlock()
+		return
+	}
+	now := c.clock.Now()
+
+	//排队时已超过截止时间的事务直接超时
+	queue := p.queue[:0]
+	for _, r := range p.queue {
+		if !r.d.IsZero() && !now.Before(r.d) {
+			expired = append(expired, r)
+			continue
+		}
+		queue = append(queue, r)
+	}
+	p.queue = queue
+
+	var next time.Time
+	for len(p.queue) > 0 {
+		if at := p.last.Add(p.pace); now.Before(at) {
+			next = at
+			break
+		}
+		//按顺序取第一个目的地址可以发送的事务
+		i := -1
+		for j, r := range p.queue {
+			at := p.lastDest[addrKey(r.addr)].Add(p.destPace)
+			if !now.Before(at) {
+				i = j
+				break
+			}
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if i < 0 {
+			break
+		}
+		r := p.queue[i]
+		p.queue = append(p.queue[:i], p.queue[i+1:]...)
+		p.last = now
+		p.lastDest[addrKey(r.addr)] = now
+		ready = append(ready, r)
+		next = time.Time{}
+	}
+	//截止时间早于下次发送时间时,按截止时间触发以便及时通知超时
+	for _, r := range p.queue {
+		if !r.d.IsZero() && (next.IsZero() || r.d.Before(next)) {
+			next = r.d
+		}
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !next.IsZero() {
+		p.timer = c.clock.AfterFunc(next.Sub(now), c.drain)
+	}
+	//清理过期的目的地址记录
+	for key, at := range p.lastDest {
+		if !now.Before(at.Add(p.destPace)) {
+			delete(p.lastDest, key)
+		}
+	}
+	c.paceMux.Unlock()
+
+	for _, r := range expired {
+		r.f(AgentEvent{
+			Error: ErrTransactionTimeOut,
+		})
+	}
+	for _, r := range ready {
+		if err := c.send(r.m, r.addr, r.d, r.f, r.prev); err != nil {
+			r.f(AgentEvent{
+				Error: err,
+			})
+		}
+	}
+}
+
+//停止发送,排队中的事务返回ErrClientClosed
+func (c *Client) stopPacing() {
+	c.paceMux.Lock()
+	p := &c.pacer
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	queue := p.queue
+	p.queue = nil
+	c.paceMux.Unlock()
+	for _, r := range queue {
+		r.f(AgentEvent{
+			Error: ErrClientClosed,
+		})
+	}
+}
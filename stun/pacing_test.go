@@ -0,0 +1,157 @@
+package stun_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+//等待server收到一个请求,received为false时确认没有收到
+func expectRequest(t *testing.T, server *net.UDPConn, received bool) {
+	t.Helper()
+	wait := time.Millisecond * 50
+	if received {
+		wait = time.Second * 5
+	}
+	if err := server.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	_, _, err := server.ReadFrom(buf)
+	if received && err != nil {
+		t.Fatalf("request is not received: %v", err)
+	}
+	if !received && err == nil {
+		t.Fatal("unexpected request")
+	}
+}
+
+func TestClientPace(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+		Pace:       stun.DefaultPace,
+	})
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err := c.Start(m, clock.Now().Add(time.Minute), func(stun.AgentEvent) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRequest(t, server, true)
+	expectRequest(t, server, false)
+	clock.Advance(stun.DefaultPace)
+	expectRequest(t, server, true)
+	expectRequest(t, server, false)
+	clock.Advance(stun.DefaultPace)
+	expectRequest(t, server, true)
+}
+
+func TestClientPaceDeadline(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+		Pace:       stun.DefaultPace,
+	})
+	defer c.Close()
+
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.Start(m, clock.Now().Add(time.Minute), func(stun.AgentEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan stun.AgentEvent, 1)
+	m = stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := c.Start(m, clock.Now().Add(time.Millisecond*20), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectRequest(t, server, true)
+	//排队时超过截止时间,不再发送
+	clock.Advance(time.Millisecond * 20)
+	select {
+	case e := <-done:
+		if e.Error != stun.ErrTransactionTimeOut {
+			t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued transaction is not timed out")
+	}
+	clock.Advance(stun.DefaultPace)
+	expectRequest(t, server, false)
+}
+
+func TestClientPacePerDestination(t *testing.T) {
+	server1 := listenLoopback(t)
+	defer server1.Close()
+	server2 := listenLoopback(t)
+	defer server2.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection:         listenLoopback(t),
+		ServerAddr:         server1.LocalAddr(),
+		Clock:              clock,
+		PacePerDestination: time.Millisecond * 100,
+	})
+	defer c.Close()
+
+	start := func() {
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err := c.Start(m, clock.Now().Add(time.Minute), func(stun.AgentEvent) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start()
+	start()
+	if err := c.ChangeServerAddr(server2.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	start()
+	//发往server2的请求不被server1的排队阻塞
+	expectRequest(t, server1, true)
+	expectRequest(t, server2, true)
+	expectRequest(t, server1, false)
+	clock.Advance(time.Millisecond * 100)
+	expectRequest(t, server1, true)
+}
+
+func TestClientPaceClose(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: listenLoopback(t),
+		ServerAddr: server.LocalAddr(),
+		Clock:      clock,
+		Pace:       stun.DefaultPace,
+	})
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err := c.Start(m, clock.Now().Add(time.Minute), func(e stun.AgentEvent) {
+			done <- e.Error
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err == nil {
+			t.Fatal("transaction is not stopped")
+		}
+	}
+}
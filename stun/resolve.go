@@ -0,0 +1,163 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoServerAddr = errors.New("no server address resolved")
+)
+
+//DNS解析接口,*net.Resolver实现了该接口,测试时可替换
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+//解析URI得到按优先顺序排列的服务器地址(ip:port)
+//
+//域名且没有指定端口时先查询SRV记录(RFC 5389 9, RFC 7064),按优先级和权重排序,
+//没有SRV记录时直接解析域名;同一主机的IPv6和IPv4地址交替排列,IPv6在前(RFC 8305)
+func ResolveURI(ctx context.Context, r Resolver, u *URI) ([]string, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if ip := net.ParseIP(u.Host); ip != nil {
+		return []string{u.Addr()}, nil
+	}
+
+	var addrs []string
+	if !u.ExplicitPort {
+		_, srvs, err := r.LookupSRV(ctx, u.Scheme, u.Transport, u.Host)
+		if err == nil {
+			for _, srv := range orderSRV(srvs) {
+				ips, err := r.LookupIPAddr(ctx, srv.Target)
+				if err != nil {
+					continue
+				}
+				addrs = append(addrs, joinIPs(interleaveIPs(ips), int(srv.Port))...)
+			}
+			if len(addrs) > 0 {
+				return addrs, nil
+			}
+		}
+	}
+
+	ips, err := r.LookupIPAddr(ctx, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	addrs = joinIPs(interleaveIPs(ips), u.Port)
+	if len(addrs) == 0 {
+		return nil, ErrNoServerAddr
+	}
+	return addrs, nil
+}
+
+//按优先级升序排列,同优先级按权重随机排列(RFC 2782)
+func orderSRV(srvs []*net.SRV) []*net.SRV {
+	//单独一条"."记录表示服务不可用
+	if len(srvs) == 1 && (srvs[0].Target == "." || srvs[0].Target == "") {
+		return nil
+	}
+	sorted := append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	result := make([]*net.SRV, 0, len(sorted))
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+			j++
+		}
+		result = append(result, shuffleByWeight(sorted[i:j])...)
+		i = j
+	}
+	return result
+}
+
+func shuffleByWeight(srvs []*net.SRV) []*net.SRV {
+	rest := append([]*net.SRV(nil), srvs...)
+	result := make([]*net.SRV, 0, len(rest))
+	for len(rest) > 0 {
+		total := 0
+		for _, srv := range rest {
+			total += int(srv.Weight)
+		}
+		i := 0
+		if total > 0 {
+			n := rand.Intn(total + 1)
+			sum := 0
+			for i = range rest {
+				sum += int(rest[i].Weight)
+				if sum >= n {
+					break
+				}
+			}
+		}
+		result = append(result, rest[i])
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return result
+}
+
+//IPv6和IPv4交替排列,IPv6在前
+func interleaveIPs(ips []net.IPAddr) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	result := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			result = append(result, v6[i])
+		}
+		if i < len(v4) {
+			result = append(result, v4[i])
+		}
+	}
+	return result
+}
+
+func joinIPs(ips []net.IPAddr, port int) []string {
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		host := ip.IP.String()
+		if ip.Zone != "" {
+			host += "%" + ip.Zone
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return addrs
+}
+
+//依次尝试地址直到拨号成功,ctx取消时停止尝试
+func dialAny(addrs []string, dial func(ctx context.Context, addr string) (net.Conn, error)) dialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		var errs []string
+		for _, addr := range addrs {
+			conn, err := dial(ctx, addr)
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, err.Error())
+		}
+		if len(errs) == 0 {
+			return nil, ErrNoServerAddr
+		}
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+}
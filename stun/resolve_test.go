@@ -0,0 +1,175 @@
+package stun_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+type stubResolver struct {
+	srv map[string][]*net.SRV
+	ip  map[string][]net.IPAddr
+}
+
+func (r stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	key := "_" + service + "._" + proto + "." + name
+	srvs, ok := r.srv[key]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return key, srvs, nil
+}
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ip[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs
+}
+
+func TestResolveURI(t *testing.T) {
+	r := stubResolver{
+		srv: map[string][]*net.SRV{
+			"_stun._udp.example.org": {
+				{Target: "b.example.org", Port: 3480, Priority: 20},
+				{Target: "a.example.org", Port: 3479, Priority: 10},
+			},
+		},
+		ip: map[string][]net.IPAddr{
+			"a.example.org":     ipAddrs("127.0.0.1", "127.0.0.3", "::1"),
+			"b.example.org":     ipAddrs("127.0.0.2"),
+			"example.org":       ipAddrs("127.0.0.9"),
+			"nosrv.example.org": ipAddrs("::2", "127.0.0.8"),
+		},
+	}
+	for _, tc := range []struct {
+		uri   string
+		addrs []string
+	}{
+		{"stun:example.org", []string{"[::1]:3479", "127.0.0.1:3479", "127.0.0.3:3479", "127.0.0.2:3480"}},
+		{"stun:example.org:3000", []string{"127.0.0.9:3000"}},
+		//显式指定默认端口时也不查询SRV
+		{"stun:example.org:3478", []string{"127.0.0.9:3478"}},
+		{"stun:nosrv.example.org", []string{"[::2]:3478", "127.0.0.8:3478"}},
+		{"stun:127.0.0.1", []string{"127.0.0.1:3478"}},
+	} {
+		u, err := stun.ParseURI(tc.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := stun.ResolveURI(context.Background(), r, u)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.uri, err)
+		}
+		if fmt.Sprint(addrs) != fmt.Sprint(tc.addrs) {
+			t.Errorf("%s: got %v, want %v", tc.uri, addrs, tc.addrs)
+		}
+	}
+}
+
+func TestDialURIFailover(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+	server := listenLoopback(t)
+	defer server.Close()
+	port := func(c *net.UDPConn) uint16 {
+		return uint16(c.LocalAddr().(*net.UDPAddr).Port)
+	}
+	r := stubResolver{
+		srv: map[string][]*net.SRV{
+			"_stun._udp.example.org": {
+				{Target: "silent.example.org", Port: port(silent), Priority: 10},
+				{Target: "server.example.org", Port: port(server), Priority: 20},
+			},
+		},
+		ip: map[string][]net.IPAddr{
+			"silent.example.org": ipAddrs("127.0.0.1"),
+			"server.example.org": ipAddrs("127.0.0.1"),
+		},
+	}
+	u, err := stun.ParseURI("stun:example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	c, err := stun.DialURI(u, stun.URIOptions{
+		Client:   stun.ClientOptions{Clock: clock},
+		Resolver: r,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.ServerAddr().String(); got != "127.0.0.1:"+strconv.Itoa(int(port(silent))) {
+		t.Fatalf("got server %s, want silent server first", got)
+	}
+
+	done := make(chan stun.AgentEvent, 1)
+	f := func(e stun.AgentEvent) {
+		done <- e
+	}
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), clock.Now().Add(time.Second), f); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second * 2)
+	if e := <-done; e.Error != stun.ErrTransactionTimeOut {
+		t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+	}
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), clock.Now().Add(time.Second), f); err != nil {
+		t.Fatal(err)
+	}
+	respond(t, server, server, stun.BindingSuccess)
+	if e := <-done; e.Error != nil {
+		t.Fatal(e.Error)
+	}
+}
+
+//CHANGE-REQUEST请求超时是NAT探测的正常结果,不切换服务器
+func TestClientNoFailoverOnChangeRequest(t *testing.T) {
+	first := listenLoopback(t)
+	defer first.Close()
+	second := listenLoopback(t)
+	defer second.Close()
+	clock := stuntest.NewFakeClock(time.Unix(0, 0))
+	for _, policy := range []stun.ResponseAddrPolicy{stun.ResponseAddrDefault, stun.ResponseAddrStrict} {
+		c := stun.NewClientWithOptions(stun.ClientOptions{
+			Connection:         listenLoopback(t),
+			ServerAddr:         first.LocalAddr(),
+			Clock:              clock,
+			ResponseAddrPolicy: policy,
+		})
+		c.SetServers([]net.Addr{first.LocalAddr(), second.LocalAddr()})
+
+		done := make(chan stun.AgentEvent, 1)
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest, stun.ChangeRequest{ChangeIP: true, ChangePort: true})
+		if err := c.SendMessage(m, clock.Now().Add(time.Second), func(e stun.AgentEvent) {
+			done <- e
+		}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second * 2)
+		if e := <-done; e.Error != stun.ErrTransactionTimeOut {
+			t.Fatalf("got %v, want %v", e.Error, stun.ErrTransactionTimeOut)
+		}
+		if got := c.ServerAddr(); got.String() != first.LocalAddr().String() {
+			t.Errorf("policy %d: server switched to %s", policy, got)
+		}
+		c.Close()
+	}
+}
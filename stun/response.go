@@ -0,0 +1,236 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrResponseAddrMismatch = errors.New("response source address mismatch")
+	ErrResponseTypeMismatch = errors.New("response method or class mismatch")
+)
+
+//响应来源地址校验策略
+type ResponseAddrPolicy int
+
+const (
+	//请求带CHANGE-REQUEST时不校验来源地址,否则来源必须是请求目的地址
+	ResponseAddrDefault ResponseAddrPolicy = iota
+	//来源必须是请求目的地址
+	ResponseAddrStrict
+	//不校验来源地址,用于RFC 5780测试中从备用地址返回响应的情况
+	ResponseAddrAny
+)
+
+//客户端记录的事务信息
+type clientTransaction struct {
+	addr    net.Addr
+	method  Method
+	anyAddr bool
+	change  bool // 请求带CHANGE-REQUEST,超时是NAT探测的正常结果
+	start   time.Time
+
+	raw           []byte // 重传用的请求数据
+	req           []byte // 重定向和认证用的原始请求,不带凭证
+	deadline      time.Time
+	f             AgentFn
+	visited       []net.Addr       // 重定向经过的地址,第一个是原始服务器
+	key           MessageIntegrity // 带凭证时校验响应
+	authTries     int              // 认证重发次数
+	rto           time.Duration    // 下次重传等待时间
+	transmissions int
+	timer         Timer
+
+	remote   net.Addr  // 响应来源地址
+	received time.Time // 收到响应的时间
+}
+
+//记录事务的目的地址和方法
+func (c *Client) addTransaction(m *Message, t *clientTransaction) error {
+	t.method = m.Type.Method
+	t.transmissions = 1
+	t.start = c.clock.Now()
+	t.raw = append([]byte(nil), m.Raw...)
+	if t.key == nil {
+		t.req = t.raw
+	}
+	_, t.change = m.Attributes.Get(AttrChangeRequest)
+	switch {
+	case c.bound:
+		//流连接只和一个服务器通信,重连后服务器地址可能改变
+		t.anyAddr = true
+	case c.policy == ResponseAddrAny:
+		t.anyAddr = true
+	case c.policy == ResponseAddrDefault:
+		t.anyAddr = t.change
+	}
+	c.tMux.Lock()
+	defer c.tMux.Unlock()
+	if _, exists := c.t[m.TransactionID]; exists {
+		return ErrTransactionExists
+	}
+	key := addrKey(t.addr)
+	if c.maxDest > 0 && c.dest[key] >= c.maxDest {
+		return ErrTooManyTransactions
+	}
+	c.dest[key]++
+	c.t[m.TransactionID] = t
+	atomic.AddInt64(&c.stats.inFlight, 1)
+	atomic.AddInt64(&c.stats.transactions, 1)
+	return nil
+}
+
+//进行中的事务id
+func (c *Client) transactionIDs() []transactionID {
+	c.tMux.Lock()
+	defer c.tMux.Unlock()
+	ids := make([]transactionID, 0, len(c.t))
+	for id := range c.t {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (c *Client) removeTransaction(id transactionID) *clientTransaction {
+	c.tMux.Lock()
+	t, ok := c.t[id]
+	if ok {
+		delete(c.t, id)
+		key := addrKey(t.addr)
+		if c.dest[key]--; c.dest[key] <= 0 {
+			delete(c.dest, key)
+		}
+	}
+	c.tMux.Unlock()
+	if !ok {
+		return nil
+	}
+	atomic.AddInt64(&c.stats.inFlight, -1)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	return t
+}
+
+//事务结束时清除记录并统计,300响应时重定向到备用服务器,401/438响应时带凭证重发
+func (c *Client) wrapTransaction(id transactionID, f AgentFn) AgentFn {
+	return func(e AgentEvent) {
+		t := c.removeTransaction(id)
+		if t == nil {
+			f(e)
+			return
+		}
+		c.tMux.Lock()
+		e.Sent = t.start
+		e.Transmissions = t.transmissions
+		if e.Message != nil {
+			e.Received = t.received
+			e.Remote = t.remote
+		}
+		c.tMux.Unlock()
+		if e.Message != nil {
+			if e.Received.IsZero() {
+				e.Received = c.clock.Now()
+			}
+			e.RTT = e.Received.Sub(e.Sent)
+		}
+		switch {
+		case e.Message != nil:
+			atomic.AddInt64(&c.stats.responses, 1)
+			c.stats.observeRTT(t.addr, e.RTT)
+		case e.Error == ErrTransactionTimeOut:
+			atomic.AddInt64(&c.stats.timeouts, 1)
+			//从其他地址响应的请求没有响应不代表服务器不可用
+			if !t.anyAddr && !t.change {
+				c.failover(t.addr)
+			}
+		}
+		if len(t.visited) > 1 {
+			e.Redirects = t.visited[1:]
+		}
+		if e.Message != nil && (c.tryAlternate(t, e.Message) || c.tryAuthenticate(t, e.Message)) {
+			return
+		}
+		f(e)
+	}
+}
+
+//校验响应,非本客户端发起的事务不校验,校验通过时记录来源地址和接收时间
+func (c *Client) checkResponse(m *Message, addr net.Addr) error {
+	now := c.clock.Now()
+	c.tMux.Lock()
+	t, ok := c.t[m.TransactionID]
+	c.tMux.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := c.validResponse(t, m, addr); err != nil {
+		return err
+	}
+	c.tMux.Lock()
+	t.remote = addr
+	t.received = now
+	c.tMux.Unlock()
+	return nil
+}
+
+//校验截断消息的头部,要求是本客户端发起的事务且来源和类型匹配
+func (c *Client) checkTruncated(id transactionID, typ MessageType, addr net.Addr) error {
+	c.tMux.Lock()
+	t, ok := c.t[id]
+	c.tMux.Unlock()
+	if !ok {
+		return ErrTransactionNotExists
+	}
+	return t.validHeader(typ, addr)
+}
+
+//校验响应的方法、类型和来源地址
+func (t *clientTransaction) validHeader(typ MessageType, addr net.Addr) error {
+	if typ.Method != t.method {
+		return ErrResponseTypeMismatch
+	}
+	if typ.Class != ClassSuccessResponse && typ.Class != ClassErrorResponse {
+		return ErrResponseTypeMismatch
+	}
+	if !t.anyAddr && !sameAddr(t.addr, addr) {
+		return ErrResponseAddrMismatch
+	}
+	return nil
+}
+
+func (c *Client) validResponse(t *clientTransaction, m *Message, addr net.Addr) error {
+	if err := t.validHeader(m.Type, addr); err != nil {
+		return err
+	}
+	//带凭证的请求,成功响应必须有正确的MESSAGE-INTEGRITY
+	if t.key != nil {
+		err := t.key.Check(m)
+		if err != nil && (err != ErrAttributeNotFound || m.Type.Class == ClassSuccessResponse) {
+			return ErrIntegrityMismatch
+		}
+	}
+	return nil
+}
+
+func addrKey(addr net.Addr) string {
+	if addr == nil {
+		return "<nil>"
+	}
+	return addr.String()
+}
+
+//比较地址,UDP地址比较IP和端口
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ua, okA := a.(*net.UDPAddr)
+	ub, okB := b.(*net.UDPAddr)
+	if okA && okB {
+		return ua.IP.Equal(ub.IP) && ua.Port == ub.Port
+	}
+	return a.String() == b.String()
+}
@@ -0,0 +1,46 @@
+package stun
+
+import "sync/atomic"
+
+//启动事务重传定时器
+func (c *Client) scheduleRetransmit(id transactionID) {
+	if c.rto <= 0 {
+		return
+	}
+	c.tMux.Lock()
+	defer c.tMux.Unlock()
+	t, ok := c.t[id]
+	if !ok {
+		return
+	}
+	if t.rto == 0 {
+		t.rto = c.rto
+	}
+	if t.transmissions >= c.maxTx {
+		//不再重传,等待事务超时
+		return
+	}
+	t.timer = c.clock.AfterFunc(t.rto, func() {
+		c.retransmit(id)
+	})
+}
+
+//重传请求,每次重传后超时时间翻倍
+func (c *Client) retransmit(id transactionID) {
+	c.tMux.Lock()
+	t, ok := c.t[id]
+	if ok {
+		t.transmissions++
+		t.rto *= 2
+	}
+	c.tMux.Unlock()
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&c.stats.retransmits, 1)
+	if _, err := c.serConn.WriteTo(t.raw, t.addr); err != nil {
+		c.stopWithError(id, err)
+		return
+	}
+	c.scheduleRetransmit(id)
+}
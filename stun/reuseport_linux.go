@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package stun
+
+//syscall包没有导出SO_REUSEPORT,除mips外Linux都是15
+const soReusePort = 0xf
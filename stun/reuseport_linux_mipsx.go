@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package stun
+
+//mips上SO_REUSEPORT是0x200
+const soReusePort = 0x200
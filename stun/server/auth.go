@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var (
+	errBadNonce   = errors.New("bad nonce")
+	errStaleNonce = errors.New("stale nonce")
+)
+
+//nonce默认有效期
+const DefaultNonceExpiry = time.Minute * 10
+
+const (
+	nonceTimeSize = 8
+	nonceMACSize  = 16
+)
+
+//凭证查找,返回用户的密码;短期凭证时realm为空
+type Credentials interface {
+	Password(username, realm string) (string, bool)
+}
+
+type CredentialsFunc func(username, realm string) (string, bool)
+
+func (f CredentialsFunc) Password(username, realm string) (string, bool) {
+	return f(username, realm)
+}
+
+//固定的用户名到密码的映射,不区分realm
+type StaticCredentials map[string]string
+
+func (c StaticCredentials) Password(username, realm string) (string, bool) {
+	p, ok := c[username]
+	return p, ok
+}
+
+//从文件读取凭证,每行一个"用户名:密码",忽略空行和#开头的行
+func LoadCredentials(path string) (StaticCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := make(StaticCredentials)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: want username:password", path, n)
+		}
+		c[line[:i]] = line[i+1:]
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//认证选项
+type AuthOptions struct {
+	//不为空时使用长期凭证,否则使用短期凭证
+	Realm       string
+	Credentials Credentials
+	//nonce的HMAC密钥,多个实例共享同一密钥时可互相验证nonce;为空时随机生成
+	Secret []byte
+	//nonce有效期,默认DefaultNonceExpiry
+	NonceExpiry time.Duration
+	//默认SystemClock
+	Clock stun.Clock
+}
+
+type authenticator struct {
+	realm  string
+	creds  Credentials
+	secret []byte
+	expiry time.Duration
+	clock  stun.Clock
+}
+
+//RFC 5389 10.1.2和10.2.2的凭证认证:
+//长期凭证时没有MESSAGE-INTEGRITY的请求回复带REALM和NONCE的401,nonce过期或无法验证回复438;
+//短期凭证时缺少USERNAME或MESSAGE-INTEGRITY回复400;
+//用户不存在或MESSAGE-INTEGRITY错误回复401;认证通过后所有响应都带MESSAGE-INTEGRITY。
+//nonce不保存在服务器上,由过期时间、来源IP和HMAC组成
+func Authenticate(o AuthOptions) Middleware {
+	a := &authenticator{
+		realm:  o.Realm,
+		creds:  o.Credentials,
+		secret: o.Secret,
+		expiry: o.NonceExpiry,
+		clock:  o.Clock,
+	}
+	if a.creds == nil {
+		a.creds = StaticCredentials(nil)
+	}
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			panic(err)
+		}
+	}
+	if a.expiry <= 0 {
+		a.expiry = DefaultNonceExpiry
+	}
+	if a.clock == nil {
+		a.clock = stun.SystemClock
+	}
+	return a.middleware
+}
+
+func (a *authenticator) middleware(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		key, code := a.check(r)
+		if code == 0 {
+			next.ServeSTUN(&integrityWriter{ResponseWriter: w, key: key}, r)
+			return
+		}
+		//其他类别的消息无法回复,直接丢弃
+		if r.Type.Class != stun.ClassRequest {
+			return
+		}
+		if a.realm == "" || code == stun.CodeBadRequest {
+			w.WriteError(code)
+			return
+		}
+		w.WriteError(code, stun.Realm(a.realm), stun.Nonce(a.nonce(r.Remote)))
+	})
+}
+
+//校验请求的凭证,通过时返回key,否则返回应回复的错误码
+func (a *authenticator) check(r *Request) (stun.MessageIntegrity, stun.ErrorCode) {
+	var (
+		username stun.Username
+		realm    stun.Realm
+		nonce    stun.Nonce
+	)
+	_, hasIntegrity := r.Attributes.Get(stun.AttrMessageIntegrity)
+	hasUsername := username.GetFrom(r.Message) == nil
+	if a.realm == "" {
+		if !hasIntegrity || !hasUsername {
+			return nil, stun.CodeBadRequest
+		}
+		password, ok := a.creds.Password(string(username), "")
+		if !ok {
+			return nil, stun.CodeUnauthorized
+		}
+		key := stun.NewShortTermIntegrity(password)
+		if key.Check(r.Message) != nil {
+			return nil, stun.CodeUnauthorized
+		}
+		return key, 0
+	}
+
+	if !hasIntegrity {
+		return nil, stun.CodeUnauthorized
+	}
+	if !hasUsername || realm.GetFrom(r.Message) != nil || nonce.GetFrom(r.Message) != nil {
+		return nil, stun.CodeBadRequest
+	}
+	//过期或密钥变化(重启、Reload、其他实例)导致无法验证的nonce都回复438,让客户端换用新nonce
+	if a.checkNonce(string(nonce), r.Remote) != nil {
+		return nil, stun.CodeStaleNonce
+	}
+	if string(realm) != a.realm {
+		return nil, stun.CodeUnauthorized
+	}
+	password, ok := a.creds.Password(string(username), a.realm)
+	if !ok {
+		return nil, stun.CodeUnauthorized
+	}
+	key := stun.NewLongTermIntegrity(string(username), a.realm, password)
+	if key.Check(r.Message) != nil {
+		return nil, stun.CodeUnauthorized
+	}
+	return key, 0
+}
+
+//生成nonce: base64(过期时间 || HMAC-SHA256(secret, 过期时间 || 来源IP)[:16])
+func (a *authenticator) nonce(addr net.Addr) string {
+	b := make([]byte, nonceTimeSize, nonceTimeSize+nonceMACSize)
+	binary.BigEndian.PutUint64(b, uint64(a.clock.Now().Add(a.expiry).Unix()))
+	b = append(b, a.mac(b, addr)...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *authenticator) checkNonce(nonce string, addr net.Addr) error {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != nonceTimeSize+nonceMACSize {
+		return errBadNonce
+	}
+	if !hmac.Equal(b[nonceTimeSize:], a.mac(b[:nonceTimeSize], addr)) {
+		return errBadNonce
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	if !a.clock.Now().Before(expires) {
+		return errStaleNonce
+	}
+	return nil
+}
+
+func (a *authenticator) mac(expires []byte, addr net.Addr) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write(expires)
+	if ip, _, ok := hostPort(addr); ok {
+		h.Write(ip.To16())
+	}
+	return h.Sum(nil)[:nonceMACSize]
+}
+
+//认证通过的请求,响应最后添加MESSAGE-INTEGRITY
+type integrityWriter struct {
+	ResponseWriter
+	key stun.MessageIntegrity
+}
+
+func (w *integrityWriter) Write(typ stun.MessageType, setters ...stun.Setter) error {
+	return w.ResponseWriter.Write(typ, append(setters[:len(setters):len(setters)], w.key)...)
+}
+
+func (w *integrityWriter) WriteError(code stun.ErrorCode, setters ...stun.Setter) error {
+	return w.ResponseWriter.WriteError(code, append(setters[:len(setters):len(setters)], w.key)...)
+}
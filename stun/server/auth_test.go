@@ -0,0 +1,238 @@
+package server_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+const testRealm = "example.org"
+
+func startAuthServer(t *testing.T, o server.AuthOptions) (*server.Server, net.PacketConn) {
+	t.Helper()
+	mux := server.NewServeMux()
+	mux.Handle(stun.BindingRequest, server.Binding)
+	return startServer(t, server.Options{Handler: server.Chain(mux, server.Authenticate(o))})
+}
+
+func mustBuild(t *testing.T, setters ...stun.Setter) []byte {
+	t.Helper()
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID, stun.BindingRequest}, setters...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Raw
+}
+
+//带凭证的请求,返回响应
+func longTermRequest(t *testing.T, addr net.Addr, user, password string, nonce stun.Nonce) *stun.Message {
+	t.Helper()
+	key := stun.NewLongTermIntegrity(user, testRealm, password)
+	res, _ := roundTrip(t, addr, mustBuild(t, stun.Username(user), stun.Realm(testRealm), nonce, key, stun.Fingerprint))
+	return res
+}
+
+//读取401/438响应中的REALM和NONCE
+func challenge(t *testing.T, res *stun.Message, want stun.ErrorCode) stun.Nonce {
+	t.Helper()
+	if code := errorCode(t, res); code != want {
+		t.Fatalf("got %d, want %d", code, want)
+	}
+	var (
+		realm stun.Realm
+		nonce stun.Nonce
+	)
+	if err := realm.GetFrom(res); err != nil || realm != testRealm {
+		t.Fatalf("realm %q, %v", realm, err)
+	}
+	if err := nonce.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	return nonce
+}
+
+func TestAuthenticateLongTerm(t *testing.T) {
+	clock := stuntest.NewFakeClock(time.Now())
+	creds := server.StaticCredentials{"alice": "secret"}
+	s, conn := startAuthServer(t, server.AuthOptions{
+		Realm:       testRealm,
+		Credentials: creds,
+		NonceExpiry: time.Minute,
+		Clock:       clock,
+	})
+	defer s.Close()
+	addr := conn.LocalAddr()
+
+	res, _ := roundTrip(t, addr, mustBuild(t))
+	nonce := challenge(t, res, stun.CodeUnauthorized)
+
+	res = longTermRequest(t, addr, "alice", "secret", nonce)
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+	if err := stun.NewLongTermIntegrity("alice", testRealm, "secret").Check(res); err != nil {
+		t.Fatalf("response integrity: %v", err)
+	}
+
+	challenge(t, longTermRequest(t, addr, "alice", "wrong", nonce), stun.CodeUnauthorized)
+	challenge(t, longTermRequest(t, addr, "bob", "secret", nonce), stun.CodeUnauthorized)
+	challenge(t, longTermRequest(t, addr, "alice", "secret", "forged"), stun.CodeStaleNonce)
+
+	//缺少NONCE
+	key := stun.NewLongTermIntegrity("alice", testRealm, "secret")
+	res, _ = roundTrip(t, addr, mustBuild(t, stun.Username("alice"), stun.Realm(testRealm), key))
+	if code := errorCode(t, res); code != stun.CodeBadRequest {
+		t.Fatalf("got %d, want %d", code, stun.CodeBadRequest)
+	}
+
+	clock.Advance(time.Minute)
+	fresh := challenge(t, longTermRequest(t, addr, "alice", "secret", nonce), stun.CodeStaleNonce)
+	if res = longTermRequest(t, addr, "alice", "secret", fresh); res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+}
+
+func TestAuthenticateSharedSecret(t *testing.T) {
+	o := server.AuthOptions{
+		Realm:       testRealm,
+		Credentials: server.StaticCredentials{"alice": "secret"},
+		Secret:      []byte("cluster secret"),
+	}
+	s1, conn1 := startAuthServer(t, o)
+	defer s1.Close()
+	s2, conn2 := startAuthServer(t, o)
+	defer s2.Close()
+
+	res, _ := roundTrip(t, conn1.LocalAddr(), mustBuild(t))
+	nonce := challenge(t, res, stun.CodeUnauthorized)
+	if res = longTermRequest(t, conn2.LocalAddr(), "alice", "secret", nonce); res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+}
+
+func TestAuthenticateShortTerm(t *testing.T) {
+	s, conn := startAuthServer(t, server.AuthOptions{
+		Credentials: server.CredentialsFunc(func(username, realm string) (string, bool) {
+			return "pass", username == "alice"
+		}),
+	})
+	defer s.Close()
+	addr := conn.LocalAddr()
+
+	res, _ := roundTrip(t, addr, mustBuild(t))
+	if code := errorCode(t, res); code != stun.CodeBadRequest {
+		t.Fatalf("got %d, want %d", code, stun.CodeBadRequest)
+	}
+	key := stun.NewShortTermIntegrity("pass")
+	res, _ = roundTrip(t, addr, mustBuild(t, stun.Username("bob"), key))
+	if code := errorCode(t, res); code != stun.CodeUnauthorized {
+		t.Fatalf("got %d, want %d", code, stun.CodeUnauthorized)
+	}
+	res, _ = roundTrip(t, addr, mustBuild(t, stun.Username("alice"), key, stun.Fingerprint))
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+	if err := key.Check(res); err != nil {
+		t.Fatalf("response integrity: %v", err)
+	}
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	s, conn := startAuthServer(t, server.AuthOptions{
+		Realm:       testRealm,
+		Credentials: server.StaticCredentials{"alice": "secret"},
+	})
+	defer s.Close()
+	local, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: local,
+		ServerAddr: conn.LocalAddr(),
+		Username:   "alice",
+		Password:   "secret",
+	})
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*2), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := <-done
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if e.Message.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", e.Message.Type)
+	}
+}
+
+//密钥变化后旧nonce无法验证,客户端收到438后换用新nonce重发
+func TestAuthenticateClientSecretChange(t *testing.T) {
+	options := func(secret string) server.Options {
+		mux := server.NewServeMux()
+		mux.Handle(stun.BindingRequest, server.Binding)
+		return server.Options{Handler: server.Chain(mux, server.Authenticate(server.AuthOptions{
+			Realm:       testRealm,
+			Credentials: server.StaticCredentials{"alice": "secret"},
+			Secret:      []byte(secret),
+		}))}
+	}
+	s, conn := startServer(t, options("one"))
+	defer s.Close()
+	local, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stun.NewClientWithOptions(stun.ClientOptions{
+		Connection: local,
+		ServerAddr: conn.LocalAddr(),
+		Username:   "alice",
+		Password:   "secret",
+	})
+	defer c.Close()
+
+	for i, secret := range []string{"one", "two"} {
+		s.Reload(options(secret))
+		e := sendBinding(t, c)
+		if e.Error != nil {
+			t.Fatalf("request %d: %v", i, e.Error)
+		}
+		if e.Message.Type != stun.BindingSuccess {
+			t.Fatalf("request %d: got %v, want success", i, e.Message.Type)
+		}
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	data := "# users\nalice:secret\n\nbob:a:b\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := server.LoadCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := c.Password("bob", testRealm); !ok || p != "a:b" {
+		t.Errorf("bob: %q %v", p, ok)
+	}
+	if len(c) != 2 {
+		t.Errorf("got %d users, want 2", len(c))
+	}
+	if err = os.WriteFile(path, []byte("alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.LoadCredentials(path); err == nil {
+		t.Error("no error for malformed line")
+	}
+}
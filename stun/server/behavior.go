@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var (
+	errPaddingWithResponsePort = errors.New("PADDING and RESPONSE-PORT in one request")
+)
+
+//绑定临时端口时的重试次数
+const listenRetries = 10
+
+//RFC 5780模式的四个套接字,下标为[ip][port]
+type natConns struct {
+	conns [2][2]net.PacketConn
+}
+
+//在两个IP、两个端口共四个套接字上监听,conns[i][j]为第i个IP的第j个端口;
+//两个IP必须是具体地址;端口为0时先在primary的IP上分配,再在alternate的IP上绑定相同端口
+func ListenNATBehavior(network string, primary, alternate *net.UDPAddr) ([2][2]net.PacketConn, error) {
+	var err error
+	for i := 0; i < listenRetries; i++ {
+		var conns [2][2]net.PacketConn
+		if conns, err = listenNATBehavior(network, primary, alternate); err == nil {
+			return conns, nil
+		}
+		//指定了端口时重试没有意义
+		if primary.Port != 0 && alternate.Port != 0 {
+			break
+		}
+	}
+	return [2][2]net.PacketConn{}, err
+}
+
+func listenNATBehavior(network string, primary, alternate *net.UDPAddr) (conns [2][2]net.PacketConn, err error) {
+	defer func() {
+		if err != nil {
+			closeNATConns(conns)
+		}
+	}()
+	ports := [2]int{primary.Port, alternate.Port}
+	ips := [2]net.IP{primary.IP, alternate.IP}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			addr := &net.UDPAddr{IP: ips[i], Port: ports[j]}
+			if conns[i][j], err = net.ListenPacket(network, addr.String()); err != nil {
+				return conns, err
+			}
+			ports[j] = conns[i][j].LocalAddr().(*net.UDPAddr).Port
+		}
+	}
+	return conns, nil
+}
+
+func closeNATConns(conns [2][2]net.PacketConn) {
+	for _, row := range conns {
+		for _, conn := range row {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+//RFC 5780 NAT行为发现模式,在ListenNATBehavior返回的四个套接字上提供服务,
+//支持CHANGE-REQUEST、RESPONSE-PORT和PADDING,响应带RESPONSE-ORIGIN和OTHER-ADDRESS;
+//任意一个套接字出错时关闭其余套接字并返回
+func (s *Server) ServeNATBehavior(conns [2][2]net.PacketConn) error {
+	nat := &natConns{conns: conns}
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+	)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			conn := conns[i][j]
+			l := &listener{
+				conn: conn,
+				nat:  nat,
+				ip:   i,
+				port: j,
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e := s.serve(conn, l)
+				once.Do(func() {
+					err = e
+					closeNATConns(conns)
+				})
+			}()
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+//按请求选择回复的套接字和目的地址,返回Binding响应的附加属性
+func (n *natConns) binding(l *listener, req *stun.Message, res *response, setters []stun.Setter) ([]stun.Setter, error) {
+	var (
+		change  stun.ChangeRequest
+		port    stun.ResponsePort
+		padding stun.Padding
+	)
+	hasPort := port.GetFrom(req) == nil
+	hasPadding := padding.GetFrom(req) == nil
+	if hasPort && hasPadding {
+		return nil, errPaddingWithResponsePort
+	}
+	if _, ok := req.Attributes.Get(stun.AttrChangeRequest); ok {
+		if err := change.GetFrom(req); err != nil {
+			return nil, err
+		}
+	}
+	i, j := l.ip, l.port
+	if change.ChangeIP {
+		i ^= 1
+	}
+	if change.ChangePort {
+		j ^= 1
+	}
+	res.conn = n.conns[i][j]
+	if hasPort {
+		ip, _, _ := hostPort(res.addr)
+		res.addr = &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+
+	origin, ok := udpAddr(res.conn.LocalAddr())
+	other, ok2 := udpAddr(n.conns[l.ip^1][l.port^1].LocalAddr())
+	if ok && ok2 {
+		setters = append(setters,
+			stun.ResponseOrigin{IP: origin.IP, Port: origin.Port},
+			stun.OtherAddress{IP: other.IP, Port: other.Port},
+		)
+	}
+	if hasPadding {
+		setters = append(setters, padding)
+	}
+	return setters, nil
+}
+
+func udpAddr(addr net.Addr) (*net.UDPAddr, bool) {
+	a, ok := addr.(*net.UDPAddr)
+	return a, ok
+}
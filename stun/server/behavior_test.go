@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+func startNATServer(t *testing.T) (*server.Server, [2][2]net.PacketConn) {
+	t.Helper()
+	conns, err := server.ListenNATBehavior("udp4",
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)},
+	)
+	if err != nil {
+		t.Skipf("loopback alias is not available: %v", err)
+	}
+	s := server.New(server.Options{})
+	go s.ServeNATBehavior(conns)
+	return s, conns
+}
+
+//发送请求,从recv读取响应,返回响应和来源地址
+func exchange(t *testing.T, send, recv net.PacketConn, to net.Addr, req *stun.Message) (*stun.Message, net.Addr) {
+	t.Helper()
+	if _, err := send.WriteTo(req.Raw, to); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.SetReadDeadline(time.Now().Add(time.Second * 2)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, from, err := recv.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &stun.Message{Raw: buf[:n]}
+	if err = res.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	return res, from
+}
+
+func TestServerNATBehavior(t *testing.T) {
+	s, conns := startNATServer(t)
+	defer s.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	primary := conns[0][0].LocalAddr()
+	for _, tc := range []struct {
+		name     string
+		change   stun.ChangeRequest
+		ip, port int
+	}{
+		{"no change", stun.ChangeRequest{}, 0, 0},
+		{"change port", stun.ChangeRequest{ChangePort: true}, 0, 1},
+		{"change ip", stun.ChangeRequest{ChangeIP: true}, 1, 0},
+		{"change both", stun.ChangeRequest{ChangeIP: true, ChangePort: true}, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := stun.MustBuild(stun.TransactionID, stun.BindingRequest, tc.change)
+			res, from := exchange(t, client, client, primary, req)
+			if res.Type != stun.BindingSuccess {
+				t.Fatalf("got %v, want success", res.Type)
+			}
+			want := conns[tc.ip][tc.port].LocalAddr().String()
+			if from.String() != want {
+				t.Fatalf("response from %s, want %s", from, want)
+			}
+			var (
+				origin stun.ResponseOrigin
+				other  stun.OtherAddress
+			)
+			if err := origin.GetFrom(res); err != nil || origin.String() != want {
+				t.Fatalf("RESPONSE-ORIGIN %s, %v, want %s", origin, err, want)
+			}
+			if err := other.GetFrom(res); err != nil || other.String() != conns[1][1].LocalAddr().String() {
+				t.Fatalf("OTHER-ADDRESS %s, %v, want %s", other, err, conns[1][1].LocalAddr())
+			}
+		})
+	}
+}
+
+func TestServerNATBehaviorResponsePort(t *testing.T) {
+	s, conns := startNATServer(t)
+	defer s.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	other, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	port := other.LocalAddr().(*net.UDPAddr).Port
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest, stun.ResponsePort(port))
+	res, _ := exchange(t, client, other, conns[0][0].LocalAddr(), req)
+	var mapped stun.XORMappedAddress
+	if err = mapped.GetFrom(res); err != nil || mapped.String() != client.LocalAddr().String() {
+		t.Fatalf("XOR-MAPPED-ADDRESS %s, %v, want %s", mapped, err, client.LocalAddr())
+	}
+
+	req = stun.MustBuild(stun.TransactionID, stun.BindingRequest, stun.Padding(1200))
+	res, _ = exchange(t, client, client, conns[0][0].LocalAddr(), req)
+	var padding stun.Padding
+	if err = padding.GetFrom(res); err != nil || padding != 1200 {
+		t.Fatalf("PADDING %d, %v", padding, err)
+	}
+
+	req = stun.MustBuild(stun.TransactionID, stun.BindingRequest, stun.Padding(8), stun.ResponsePort(port))
+	res, _ = exchange(t, client, client, conns[0][0].LocalAddr(), req)
+	if code := errorCode(t, res); code != stun.CodeBadRequest {
+		t.Fatalf("got %d, want %d", code, stun.CodeBadRequest)
+	}
+}
+
+//非RFC 5780模式不支持CHANGE-REQUEST
+func TestServerChangeRequestUnsupported(t *testing.T) {
+	s, conn := startServer(t, server.Options{})
+	defer s.Close()
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest, stun.ChangeRequest{ChangePort: true})
+	res, _ := roundTrip(t, conn.LocalAddr(), req.Raw)
+	if code := errorCode(t, res); code != stun.CodeUnknownAttribute {
+		t.Fatalf("got %d, want %d", code, stun.CodeUnknownAttribute)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+const (
+	//RFC 5389 7.3.1:服务器对重传请求返回相同响应,缓存时间为40秒(Ti)
+	cacheTTL = time.Second * 40
+	//默认最多缓存的响应数
+	DefaultCacheSize = 10000
+	//默认缓存响应的总字节数上限
+	DefaultCacheBytes = 4 << 20
+)
+
+// 同一来源、同一本地套接字、同一事务id的请求视为重传
+type cacheKey struct {
+	conn packetWriter
+	addr string
+	id   [16]byte // 消息头第4到20字节,包含RFC 3489事务id的前4字节
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	raw     []byte
+	res     response
+	expires time.Time
+}
+
+// 重传响应缓存,按插入顺序过期,响应数或总字节数超出上限时淘汰最早的
+type responseCache struct {
+	size     int
+	maxBytes int
+	clock    stun.Clock
+
+	mux     sync.Mutex
+	entries map[cacheKey]*list.Element
+	order   *list.List
+	bytes   int // 已缓存响应的总字节数
+}
+
+func newResponseCache(size, maxBytes int, clock stun.Clock) *responseCache {
+	return &responseCache{
+		size:     size,
+		maxBytes: maxBytes,
+		clock:    clock,
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func newCacheKey(conn packetWriter, addr net.Addr, b []byte) cacheKey {
+	k := cacheKey{conn: conn, addr: addrKey(addr)}
+	copy(k.id[:], b[4:20])
+	return k
+}
+
+func addrKey(addr net.Addr) string {
+	if addr == nil {
+		return "<nil>"
+	}
+	return addr.String()
+}
+
+// 返回未过期的响应
+func (c *responseCache) get(k cacheKey) (*cacheEntry, bool) {
+	now := c.clock.Now()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.expire(now)
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(*cacheEntry), true
+}
+
+// 缓存响应,超过总字节数上限的响应不缓存
+func (c *responseCache) put(k cacheKey, raw []byte, res response) {
+	now := c.clock.Now()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.expire(now)
+	if e, ok := c.entries[k]; ok {
+		c.remove(e)
+	}
+	if len(raw) > c.maxBytes {
+		return
+	}
+	for c.order.Len() >= c.size || c.bytes+len(raw) > c.maxBytes {
+		c.remove(c.order.Front())
+	}
+	c.bytes += len(raw)
+	c.entries[k] = c.order.PushBack(&cacheEntry{
+		key:     k,
+		raw:     append([]byte(nil), raw...),
+		res:     res,
+		expires: now.Add(cacheTTL),
+	})
+}
+
+func (c *responseCache) expire(now time.Time) {
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*cacheEntry).expires); e = c.order.Front() {
+		c.remove(e)
+	}
+}
+
+func (c *responseCache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.order.Remove(e)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.raw)
+}
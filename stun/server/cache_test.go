@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+//每次调用返回不同SOFTWARE的Handler
+func countingHandler(calls *int64) server.Handler {
+	return paddedHandler(calls, 0)
+}
+
+//SOFTWARE补齐到至少size字节
+func paddedHandler(calls *int64, size int) server.Handler {
+	return server.HandlerFunc(func(w server.ResponseWriter, r *server.Request) {
+		n := strconv.FormatInt(atomic.AddInt64(calls, 1), 10)
+		if len(n) < size {
+			n += strings.Repeat(" ", size-len(n))
+		}
+		w.Write(stun.BindingSuccess, stun.Software(n))
+	})
+}
+
+func TestServerRetransmitCache(t *testing.T) {
+	var calls int64
+	clock := stuntest.NewFakeClock(time.Now())
+	s, conn := startServer(t, server.Options{Handler: countingHandler(&calls), Clock: clock})
+	defer s.Close()
+	addr := conn.LocalAddr()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	req := stun.MustBuild(stun.BindingRequest)
+	first, _ := exchange(t, client, client, addr, req)
+	clock.Advance(time.Second * 39)
+	again, _ := exchange(t, client, client, addr, req)
+	if !bytes.Equal(first.Raw, again.Raw) {
+		t.Error("retransmission got a different response")
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+
+	//其他来源的相同事务id不使用缓存
+	roundTrip(t, addr, req.Raw)
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+
+	clock.Advance(time.Second)
+	exchange(t, client, client, addr, req)
+	if n := atomic.LoadInt64(&calls); n != 3 {
+		t.Fatalf("handler called %d times after expiry, want 3", n)
+	}
+}
+
+func TestServerRetransmitCacheSize(t *testing.T) {
+	var calls int64
+	s, conn := startServer(t, server.Options{Handler: countingHandler(&calls), CacheSize: 1})
+	defer s.Close()
+	addr := conn.LocalAddr()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	a := stun.MustBuild(stun.BindingRequest)
+	b := stun.MustBuild(stun.BindingRequest)
+	for _, req := range []*stun.Message{a, a, b, a} {
+		exchange(t, client, client, addr, req)
+	}
+	if n := atomic.LoadInt64(&calls); n != 3 {
+		t.Fatalf("handler called %d times, want 3", n)
+	}
+
+	var disabled int64
+	s2, conn2 := startServer(t, server.Options{Handler: countingHandler(&disabled), CacheSize: -1})
+	defer s2.Close()
+	exchange(t, client, client, conn2.LocalAddr(), a)
+	exchange(t, client, client, conn2.LocalAddr(), a)
+	if n := atomic.LoadInt64(&disabled); n != 2 {
+		t.Fatalf("handler called %d times without cache, want 2", n)
+	}
+}
+
+func TestServerRetransmitCacheBytes(t *testing.T) {
+	var calls int64
+	//每个响应600多字节,只能缓存一个
+	s, conn := startServer(t, server.Options{Handler: paddedHandler(&calls, 600), CacheBytes: 1000})
+	defer s.Close()
+	addr := conn.LocalAddr()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	a := stun.MustBuild(stun.BindingRequest)
+	b := stun.MustBuild(stun.BindingRequest)
+	for _, req := range []*stun.Message{a, b, a, a} {
+		exchange(t, client, client, addr, req)
+	}
+	if n := atomic.LoadInt64(&calls); n != 3 {
+		t.Fatalf("handler called %d times, want 3", n)
+	}
+
+	//超过上限的响应不缓存
+	var large int64
+	s2, conn2 := startServer(t, server.Options{Handler: paddedHandler(&large, 1200), CacheBytes: 1000})
+	defer s2.Close()
+	exchange(t, client, client, conn2.LocalAddr(), a)
+	exchange(t, client, client, conn2.LocalAddr(), a)
+	if n := atomic.LoadInt64(&large); n != 2 {
+		t.Fatalf("handler called %d times for oversized response, want 2", n)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//RFC 3489兼容模式:
+//接受没有magic cookie的请求,事务id为128位,响应原样带回;
+//Binding响应带MAPPED-ADDRESS、SOURCE-ADDRESS,RFC 5780模式下带CHANGED-ADDRESS;
+//请求带RESPONSE-ADDRESS时响应发到该地址并带REFLECTED-FROM。
+//RESPONSE-ADDRESS可以让服务器向任意地址发送数据,会被用来做反射攻击,
+//RFC 5389因此废弃了它,只应在可信网络内开启兼容模式
+
+//兼容模式下额外支持的属性
+var classicAttributes = map[stun.AttrType]bool{
+	stun.AttrResponseAddress: true,
+}
+
+//头部符合RFC 3489格式
+func isClassic(b []byte) bool {
+	if len(b) < 20 || b[0]&0xc0 != 0 {
+		return false
+	}
+	size := int(binary.BigEndian.Uint16(b[2:4]))
+	return size%4 == 0 && 20+size <= len(b)
+}
+
+//把RFC 3489消息的事务id前4字节换成magic cookie以便解码,返回新消息和原来的4字节
+func fromClassic(b []byte) ([]byte, []byte) {
+	raw := append([]byte(nil), b...)
+	prefix := append([]byte(nil), raw[4:8]...)
+	binary.BigEndian.PutUint32(raw[4:8], stun.MagicCookie)
+	return raw, prefix
+}
+
+//添加RFC 3489的地址属性,处理RESPONSE-ADDRESS
+func classicBinding(l *listener, req *stun.Message, res *response, setters []stun.Setter) ([]stun.Setter, error) {
+	if source, ok := udpAddr(res.conn.LocalAddr()); ok {
+		setters = append(setters, stun.SourceAddress{IP: source.IP, Port: source.Port})
+	}
+	if l.nat != nil {
+		if changed, ok := udpAddr(l.nat.conns[l.ip^1][l.port^1].LocalAddr()); ok {
+			setters = append(setters, stun.ChangedAddress{IP: changed.IP, Port: changed.Port})
+		}
+	}
+	if _, ok := req.Attributes.Get(stun.AttrResponseAddress); !ok {
+		return setters, nil
+	}
+	var to stun.ResponseAddress
+	if err := to.GetFrom(req); err != nil {
+		return nil, err
+	}
+	if ip, port, ok := hostPort(res.addr); ok {
+		setters = append(setters, stun.ReflectedFrom{IP: ip, Port: port})
+	}
+	res.addr = &net.UDPAddr{IP: to.IP, Port: to.Port}
+	return setters, nil
+}
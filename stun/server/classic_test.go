@@ -0,0 +1,180 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+//构造RFC 3489请求,事务id前4字节不是magic cookie
+func classicRequest(t *testing.T, setters ...stun.Setter) []byte {
+	t.Helper()
+	m := stun.MustBuild(append([]stun.Setter{stun.BindingRequest}, setters...)...)
+	copy(m.Raw[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	return m.Raw
+}
+
+//读取RFC 3489响应,校验事务id后还原magic cookie解码
+func readClassic(t *testing.T, conn net.PacketConn, req []byte) (*stun.Message, net.Addr) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second * 2)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 20 || !bytes.Equal(buf[4:20], req[4:20]) {
+		t.Fatal("transaction id is not echoed")
+	}
+	binary.BigEndian.PutUint32(buf[4:8], stun.MagicCookie)
+	res := &stun.Message{Raw: buf[:n]}
+	if err = res.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	return res, from
+}
+
+func listenUDP(t *testing.T, addr string) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestServerClassic(t *testing.T) {
+	s, conn := startServer(t, server.Options{Classic: true})
+	defer s.Close()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	req := classicRequest(t)
+	if _, err := client.WriteTo(req, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := readClassic(t, client, req)
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+	var mapped stun.MappedAddress
+	if err := mapped.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if mapped.String() != client.LocalAddr().String() {
+		t.Errorf("MAPPED-ADDRESS %s, want %s", mapped, client.LocalAddr())
+	}
+	var source stun.SourceAddress
+	if err := source.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if source.String() != conn.LocalAddr().String() {
+		t.Errorf("SOURCE-ADDRESS %s, want %s", source, conn.LocalAddr())
+	}
+	for _, a := range []stun.AttrType{stun.AttrXORMappedAddress, stun.AttrFingerprint} {
+		if _, ok := res.Attributes.Get(a); ok {
+			t.Errorf("unexpected %v in classic response", a)
+		}
+	}
+
+	//RFC 5389请求不受影响
+	res, _ = roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if _, ok := res.Attributes.Get(stun.AttrXORMappedAddress); !ok {
+		t.Error("no XOR-MAPPED-ADDRESS in RFC 5389 response")
+	}
+}
+
+func TestServerClassicDisabled(t *testing.T) {
+	s, conn := startServer(t, server.Options{})
+	defer s.Close()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	if _, err := client.WriteTo(classicRequest(t), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetReadDeadline(time.Now().Add(time.Millisecond * 200)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.ReadFrom(make([]byte, 1500)); err == nil {
+		t.Fatal("classic request answered without Classic option")
+	}
+}
+
+func TestServerClassicResponseAddress(t *testing.T) {
+	s, conn := startServer(t, server.Options{Classic: true})
+	defer s.Close()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+	other := listenUDP(t, "127.0.0.1:0")
+	defer other.Close()
+
+	to := other.LocalAddr().(*net.UDPAddr)
+	req := classicRequest(t, stun.ResponseAddress{IP: to.IP, Port: to.Port})
+	if _, err := client.WriteTo(req, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := readClassic(t, other, req)
+	var reflected stun.ReflectedFrom
+	if err := reflected.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if reflected.String() != client.LocalAddr().String() {
+		t.Errorf("REFLECTED-FROM %s, want %s", reflected, client.LocalAddr())
+	}
+
+	//未开启兼容模式时RESPONSE-ADDRESS是不认识的属性
+	s2, conn2 := startServer(t, server.Options{})
+	defer s2.Close()
+	res, _ = roundTrip(t, conn2.LocalAddr(), stun.MustBuild(stun.BindingRequest, stun.ResponseAddress{IP: to.IP, Port: to.Port}).Raw)
+	if code := errorCode(t, res); code != stun.CodeUnknownAttribute {
+		t.Errorf("got %d, want 420", code)
+	}
+}
+
+func TestServerClassicChangedAddress(t *testing.T) {
+	conns, err := server.ListenNATBehavior("udp4",
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)},
+	)
+	if err != nil {
+		t.Skipf("loopback alias is not available: %v", err)
+	}
+	s := server.New(server.Options{Classic: true})
+	go s.ServeNATBehavior(conns)
+	defer s.Close()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	req := classicRequest(t, stun.ChangeRequest{ChangePort: true})
+	if _, err = client.WriteTo(req, conns[0][0].LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	res, from := readClassic(t, client, req)
+	if want := conns[0][1].LocalAddr().String(); from.String() != want {
+		t.Errorf("response from %s, want %s", from, want)
+	}
+	var (
+		source  stun.SourceAddress
+		changed stun.ChangedAddress
+	)
+	if err = source.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if err = changed.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if source.String() != from.String() {
+		t.Errorf("SOURCE-ADDRESS %s, want %s", source, from)
+	}
+	if want := conns[1][1].LocalAddr().String(); changed.String() != want {
+		t.Errorf("CHANGED-ADDRESS %s, want %s", changed, want)
+	}
+}
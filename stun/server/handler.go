@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var (
+	ErrResponseWritten = errors.New("response already written")
+)
+
+//处理STUN消息,与net/http的Handler类似;
+//ServeSTUN返回后Request中的数据会被复用,不能再访问
+type Handler interface {
+	ServeSTUN(w ResponseWriter, r *Request)
+}
+
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeSTUN(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+//服务器收到的消息
+type Request struct {
+	*stun.Message
+	Remote net.Addr // 来源地址
+	Local  net.Addr // 收到消息的本地地址
+
+	classic []byte    // RFC 3489请求事务id的前4字节,RFC 5389请求为nil
+	size    int       // 数据报长度
+	key     *cacheKey // 重传缓存的key,不缓存时为nil
+	cfg     *config   // 收到请求时的配置
+	l       *listener
+	res     *response
+}
+
+//是否RFC 3489请求
+func (r *Request) Classic() bool {
+	return r.classic != nil
+}
+
+//构造并发送响应
+type ResponseWriter interface {
+	//用setters构造响应发送到请求来源,自动设置事务id,添加SOFTWARE和FINGERPRINT;
+	//每个请求只能响应一次,再次调用返回ErrResponseWritten;
+	//响应目的地址不允许或响应过长时不发送,返回ErrResponseDropped
+	Write(typ stun.MessageType, setters ...stun.Setter) error
+	//发送错误响应,类型为请求方法的错误响应
+	WriteError(code stun.ErrorCode, setters ...stun.Setter) error
+}
+
+type responseWriter struct {
+	s       *Server
+	r       *Request
+	written bool
+}
+
+func (w *responseWriter) Write(typ stun.MessageType, setters ...stun.Setter) error {
+	if w.written {
+		return ErrResponseWritten
+	}
+	w.written = true
+	m, err := w.r.cfg.build(w.r, typ, setters...)
+	if err != nil {
+		return err
+	}
+	if !w.s.allowResponse(w.r, m.Raw, w.r.res.addr) {
+		return ErrResponseDropped
+	}
+	if w.r.key != nil {
+		w.s.cache.put(*w.r.key, m.Raw, *w.r.res)
+	}
+	_, err = w.r.res.conn.WriteTo(m.Raw, w.r.res.addr)
+	return err
+}
+
+func (w *responseWriter) WriteError(code stun.ErrorCode, setters ...stun.Setter) error {
+	typ := stun.NewType(w.r.Type.Method, stun.ClassErrorResponse)
+	return w.Write(typ, append([]stun.Setter{code}, setters...)...)
+}
+
+//按消息类型(方法和类别)分发的Handler;
+//没有对应Handler的请求返回400,其他类别的消息忽略
+type ServeMux struct {
+	mux sync.RWMutex
+	m   map[stun.MessageType]Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		m: make(map[stun.MessageType]Handler),
+	}
+}
+
+//注册消息类型的Handler,已注册或h为空时panic
+func (mux *ServeMux) Handle(typ stun.MessageType, h Handler) {
+	if h == nil {
+		panic("server: nil handler")
+	}
+	mux.mux.Lock()
+	defer mux.mux.Unlock()
+	if _, exists := mux.m[typ]; exists {
+		panic("server: multiple registrations for " + typ.Method.String() + " " + typ.Class.String())
+	}
+	mux.m[typ] = h
+}
+
+func (mux *ServeMux) HandleFunc(typ stun.MessageType, f func(w ResponseWriter, r *Request)) {
+	mux.Handle(typ, HandlerFunc(f))
+}
+
+//返回消息类型对应的Handler
+func (mux *ServeMux) Handler(typ stun.MessageType) (Handler, bool) {
+	mux.mux.RLock()
+	defer mux.mux.RUnlock()
+	h, ok := mux.m[typ]
+	return h, ok
+}
+
+func (mux *ServeMux) ServeSTUN(w ResponseWriter, r *Request) {
+	if h, ok := mux.Handler(r.Type); ok {
+		h.ServeSTUN(w, r)
+		return
+	}
+	if r.Type.Class == stun.ClassRequest {
+		w.WriteError(stun.CodeBadRequest)
+	}
+}
+
+//内置的Binding请求处理,按服务器的监听方式和选项支持RFC 5780和RFC 3489
+var Binding Handler = HandlerFunc(binding)
+
+func binding(w ResponseWriter, r *Request) {
+	if r.Type.Class != stun.ClassRequest {
+		return
+	}
+	l, res := r.l, r.res
+	if unknown := r.cfg.unknownAttributes(r.Message, l.nat != nil); len(unknown) > 0 {
+		w.WriteError(stun.CodeUnknownAttribute, stun.UnknownAttributes(unknown))
+		return
+	}
+	ip, port, ok := hostPort(r.Remote)
+	if !ok {
+		return
+	}
+	//RFC 3489客户端不认识XOR-MAPPED-ADDRESS
+	setters := []stun.Setter{stun.MappedAddress{IP: ip, Port: port}}
+	if r.classic == nil {
+		setters = append([]stun.Setter{stun.XORMappedAddress{IP: ip, Port: port}}, setters...)
+	}
+	var err error
+	if l.nat != nil {
+		if setters, err = l.nat.binding(l, r.Message, res, setters); err != nil {
+			w.WriteError(stun.CodeBadRequest)
+			return
+		}
+	}
+	if r.cfg.classic {
+		if setters, err = classicBinding(l, r.Message, res, setters); err != nil {
+			w.WriteError(stun.CodeBadRequest)
+			return
+		}
+	}
+	w.Write(stun.BindingSuccess, setters...)
+}
@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+var allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest)
+
+//发送数据,确认没有收到响应
+func expectSilence(t *testing.T, addr net.Addr, b []byte) {
+	t.Helper()
+	conn := listenUDP(t, "127.0.0.1:0")
+	defer conn.Close()
+	if _, err := conn.WriteTo(b, addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadFrom(make([]byte, 1500)); err == nil {
+		t.Fatal("unexpected response")
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	mux := server.NewServeMux()
+	mux.Handle(stun.BindingRequest, server.Binding)
+	mux.HandleFunc(allocateRequest, func(w server.ResponseWriter, r *server.Request) {
+		if err := w.Write(stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse), stun.Software("custom")); err != nil {
+			t.Error(err)
+		}
+		if err := w.WriteError(stun.CodeServerError); err != server.ErrResponseWritten {
+			t.Errorf("second write: got %v, want %v", err, server.ErrResponseWritten)
+		}
+	})
+	s, conn := startServer(t, server.Options{Handler: mux, Software: "server"})
+	defer s.Close()
+
+	res, _ := roundTrip(t, conn.LocalAddr(), stun.MustBuild(allocateRequest).Raw)
+	if res.Type.Method != stun.MethodAllocate || res.Type.Class != stun.ClassSuccessResponse {
+		t.Fatalf("got %v %v", res.Type.Method, res.Type.Class)
+	}
+	//服务器的SOFTWARE在Handler的属性之前
+	var software stun.Software
+	if err := software.GetFrom(res); err != nil || software != "server" {
+		t.Errorf("SOFTWARE %q, %v", software, err)
+	}
+
+	res, _ = roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+
+	indication := stun.MustBuild(stun.NewType(stun.MethodBinding, stun.ClassIndication))
+	expectSilence(t, conn.LocalAddr(), indication.Raw)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration does not panic")
+		}
+	}()
+	mux.Handle(stun.BindingRequest, server.Binding)
+}
+
+//把每次写入发送到channel,供测试读取日志
+type logWriter chan string
+
+func (w logWriter) Write(b []byte) (int, error) {
+	w <- string(b)
+	return len(b), nil
+}
+
+func TestMiddleware(t *testing.T) {
+	metrics := new(server.Metrics)
+	mux := server.NewServeMux()
+	mux.Handle(stun.BindingRequest, server.Binding)
+	mux.HandleFunc(allocateRequest, func(w server.ResponseWriter, r *server.Request) {
+		panic("boom")
+	})
+	auth := server.Auth(func(r *server.Request) bool {
+		var u stun.Username
+		return u.GetFrom(r.Message) == nil && u == "alice"
+	})
+	logged := make(logWriter, 1)
+	clock := stuntest.NewFakeClock(time.Now())
+	h := server.Chain(mux, metrics.Middleware, server.RecoverWithLogger(log.New(logged, "", 0).Printf), server.RateLimit(1, 3, clock), auth)
+	s, conn := startServer(t, server.Options{Handler: h})
+	defer s.Close()
+
+	res, _ := roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if code := errorCode(t, res); code != stun.CodeUnauthorized {
+		t.Fatalf("no username: got %d, want %d", code, stun.CodeUnauthorized)
+	}
+	res, _ = roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest, stun.Username("alice")).Raw)
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+	res, _ = roundTrip(t, conn.LocalAddr(), stun.MustBuild(allocateRequest, stun.Username("alice")).Raw)
+	if code := errorCode(t, res); code != stun.CodeServerError {
+		t.Fatalf("panic: got %d, want %d", code, stun.CodeServerError)
+	}
+	select {
+	case l := <-logged:
+		if !strings.Contains(l, "boom") {
+			t.Errorf("panic log %q, want boom", l)
+		}
+	case <-time.After(time.Second):
+		t.Error("panic not logged")
+	}
+	//令牌用完后丢弃,一秒后补充一个
+	expectSilence(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest, stun.Username("alice")).Raw)
+	clock.Advance(time.Second)
+	res, _ = roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest, stun.Username("alice")).Raw)
+	if res.Type != stun.BindingSuccess {
+		t.Fatalf("after refill: got %v, want success", res.Type)
+	}
+
+	want := server.MetricsStats{Requests: 5, Success: 2, Errors: 2, Unanswered: 1}
+	if got := metrics.Stats(); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+}
+
+//Recover默认不输出日志
+func TestRecoverSilent(t *testing.T) {
+	logged := make(logWriter, 1)
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+
+	h := server.Chain(server.HandlerFunc(func(w server.ResponseWriter, r *server.Request) {
+		panic("boom")
+	}), server.Recover)
+	s, conn := startServer(t, server.Options{Handler: h})
+	defer s.Close()
+	res, _ := roundTrip(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if code := errorCode(t, res); code != stun.CodeServerError {
+		t.Fatalf("got %d, want %d", code, stun.CodeServerError)
+	}
+	select {
+	case l := <-logged:
+		t.Fatalf("unexpected log %q", l)
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//包装Handler,添加认证、限流、统计等功能
+type Middleware func(next Handler) Handler
+
+//按顺序套上中间件,第一个在最外层
+func Chain(h Handler, m ...Middleware) Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+//记录是否已响应及响应类别
+type recorder struct {
+	ResponseWriter
+	written bool
+	class   stun.MessageClass
+}
+
+func (w *recorder) Write(typ stun.MessageType, setters ...stun.Setter) error {
+	w.written = true
+	w.class = typ.Class
+	return w.ResponseWriter.Write(typ, setters...)
+}
+
+func (w *recorder) WriteError(code stun.ErrorCode, setters ...stun.Setter) error {
+	w.written = true
+	w.class = stun.ClassErrorResponse
+	return w.ResponseWriter.WriteError(code, setters...)
+}
+
+//认证,check返回false的请求回复401,其他类别的消息直接丢弃
+func Auth(check func(r *Request) bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			if check(r) {
+				next.ServeSTUN(w, r)
+				return
+			}
+			if r.Type.Class == stun.ClassRequest {
+				w.WriteError(stun.CodeUnauthorized)
+			}
+		})
+	}
+}
+
+//令牌桶,每秒补充rate个令牌,最多burst个
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//全局限流,每秒最多处理rate个消息,允许burst个突发;超出的消息直接丢弃,不回复错误以免被放大利用,
+//clock为nil时使用SystemClock
+func RateLimit(rate float64, burst int, clock stun.Clock) Middleware {
+	if clock == nil {
+		clock = stun.SystemClock
+	}
+	var mux sync.Mutex
+	bucket := newTokenBucket(rate, burst, clock.Now())
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			mux.Lock()
+			ok := bucket.allow(clock.Now())
+			mux.Unlock()
+			if ok {
+				next.ServeSTUN(w, r)
+			}
+		})
+	}
+}
+
+//处理统计,用Middleware方法接入
+type Metrics struct {
+	requests    int64
+	success     int64
+	errors      int64
+	unanswered  int64
+	indications int64
+}
+
+//统计快照
+type MetricsStats struct {
+	Requests    int64 // 收到的请求
+	Success     int64 // 成功响应
+	Errors      int64 // 错误响应
+	Unanswered  int64 // 没有响应的请求
+	Indications int64 // 收到的指示
+}
+
+func (m *Metrics) Middleware(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Type.Class == stun.ClassIndication {
+			atomic.AddInt64(&m.indications, 1)
+		}
+		if r.Type.Class != stun.ClassRequest {
+			next.ServeSTUN(w, r)
+			return
+		}
+		atomic.AddInt64(&m.requests, 1)
+		rec := &recorder{ResponseWriter: w}
+		defer func() {
+			switch {
+			case !rec.written:
+				atomic.AddInt64(&m.unanswered, 1)
+			case rec.class == stun.ClassErrorResponse:
+				atomic.AddInt64(&m.errors, 1)
+			default:
+				atomic.AddInt64(&m.success, 1)
+			}
+		}()
+		next.ServeSTUN(rec, r)
+	})
+}
+
+func (m *Metrics) Stats() MetricsStats {
+	return MetricsStats{
+		Requests:    atomic.LoadInt64(&m.requests),
+		Success:     atomic.LoadInt64(&m.success),
+		Errors:      atomic.LoadInt64(&m.errors),
+		Unanswered:  atomic.LoadInt64(&m.unanswered),
+		Indications: atomic.LoadInt64(&m.indications),
+	}
+}
+
+//捕获Handler的panic,请求还没有响应时回复500,不输出日志
+func Recover(next Handler) Handler {
+	return RecoverWithLogger(nil)(next)
+}
+
+//同Recover,panic和调用栈通过logf输出,如log.Printf或github.com/cocobao/log的Errorf;
+//logf为nil时不输出
+func RecoverWithLogger(logf func(format string, v ...interface{})) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				if v := recover(); v != nil {
+					if logf != nil {
+						logf("stun handler panic: %v\n%s", v, debug.Stack())
+					}
+					if !rec.written && r.Type.Class == stun.ClassRequest {
+						rec.WriteError(stun.CodeServerError)
+					}
+				}
+			}()
+			next.ServeSTUN(rec, r)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var (
+	ErrResponseDropped = errors.New("response dropped by server policy")
+)
+
+//限流时最多记录的来源IP数,超出后新来源的消息被丢弃
+const maxRateLimitSources = 1 << 16
+
+//被丢弃的消息和连接计数
+type Stats struct {
+	RateLimited int64 // 来源超出限流
+	Denied      int64 // 来源或响应目的地址不在允许范围内
+	Oversized   int64 // 响应超出放大倍数限制
+	Refused     int64 // 超出最大连接数被拒绝的TCP/TLS连接
+}
+
+type serverStats struct {
+	rateLimited int64
+	denied      int64
+	oversized   int64
+	refused     int64
+}
+
+//返回丢弃计数快照
+func (s *Server) Stats() Stats {
+	return Stats{
+		RateLimited: atomic.LoadInt64(&s.stats.rateLimited),
+		Denied:      atomic.LoadInt64(&s.stats.denied),
+		Oversized:   atomic.LoadInt64(&s.stats.oversized),
+		Refused:     atomic.LoadInt64(&s.stats.refused),
+	}
+}
+
+//解析CIDR列表,单个IP视为只包含该地址的网段
+func ParseCIDRs(list ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//访问控制,deny优先,allow不为空时只允许其中的地址
+type acl struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func (a acl) allowed(addr net.Addr) bool {
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return true
+	}
+	ip, _, ok := hostPort(addr)
+	if !ok || containsIP(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+//按来源IP的令牌桶限流
+type limiter struct {
+	rate  float64
+	burst int
+	clock stun.Clock
+
+	mux     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newLimiter(rate float64, burst int, clock stun.Clock) *limiter {
+	if burst < 1 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &limiter{
+		rate:    rate,
+		burst:   burst,
+		clock:   clock,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *limiter) allow(addr net.Addr) bool {
+	ip, _, ok := hostPort(addr)
+	if !ok {
+		return false
+	}
+	key := string(ip.To16())
+	now := l.clock.Now()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitSources && !l.sweep(now) {
+			return false
+		}
+		b = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = b
+	}
+	return b.allow(now)
+}
+
+//删除已经补满的桶,返回是否有空位
+func (l *limiter) sweep(now time.Time) bool {
+	full := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	return len(l.buckets) < maxRateLimitSources
+}
+
+//检查消息来源,不允许时计数并返回false
+func (s *Server) allowSource(cfg *config, addr net.Addr) bool {
+	if !cfg.acl.allowed(addr) {
+		atomic.AddInt64(&s.stats.denied, 1)
+		return false
+	}
+	if cfg.limiter != nil && !cfg.limiter.allow(addr) {
+		atomic.AddInt64(&s.stats.rateLimited, 1)
+		return false
+	}
+	return true
+}
+
+//检查响应的目的地址和长度,RESPONSE-ADDRESS等属性可能把响应引向第三方
+func (s *Server) allowResponse(req *Request, res []byte, to net.Addr) bool {
+	if !req.cfg.acl.allowed(to) {
+		atomic.AddInt64(&s.stats.denied, 1)
+		return false
+	}
+	if a := req.cfg.amplification; a > 0 && float64(len(res)) > a*float64(req.size) {
+		atomic.AddInt64(&s.stats.oversized, 1)
+		return false
+	}
+	return true
+}
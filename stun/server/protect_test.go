@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+func mustCIDRs(t *testing.T, list ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := server.ParseCIDRs(list...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestServerRateLimit(t *testing.T) {
+	clock := stuntest.NewFakeClock(time.Now())
+	s, conn := startServer(t, server.Options{RateLimit: 1, RateBurst: 2, Clock: clock})
+	defer s.Close()
+	addr := conn.LocalAddr()
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	send := func() bool {
+		req := stun.MustBuild(stun.BindingRequest)
+		if _, err := client.WriteTo(req.Raw, addr); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetReadDeadline(time.Now().Add(time.Millisecond * 200)); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := client.ReadFrom(make([]byte, 1500))
+		return err == nil
+	}
+	for i, want := range []bool{true, true, false} {
+		if got := send(); got != want {
+			t.Fatalf("request %d: answered %v, want %v", i, got, want)
+		}
+	}
+	//其他来源IP不受影响
+	if other, err := net.ListenPacket("udp4", "127.0.0.2:0"); err == nil {
+		req := stun.MustBuild(stun.BindingRequest)
+		exchange(t, other, other, addr, req)
+		other.Close()
+	}
+
+	clock.Advance(time.Second)
+	if !send() {
+		t.Fatal("not answered after refill")
+	}
+	if got := s.Stats(); got != (server.Stats{RateLimited: 1}) {
+		t.Errorf("stats %+v", got)
+	}
+}
+
+func TestServerACL(t *testing.T) {
+	s, conn := startServer(t, server.Options{Deny: mustCIDRs(t, "127.0.0.0/8")})
+	defer s.Close()
+	expectSilence(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if got := s.Stats(); got != (server.Stats{Denied: 1}) {
+		t.Errorf("deny: stats %+v", got)
+	}
+
+	s2, conn2 := startServer(t, server.Options{Allow: mustCIDRs(t, "10.0.0.0/8", "::1")})
+	defer s2.Close()
+	expectSilence(t, conn2.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+
+	s3, conn3 := startServer(t, server.Options{Allow: mustCIDRs(t, "127.0.0.1")})
+	defer s3.Close()
+	roundTrip(t, conn3.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+}
+
+func TestServerReflectionDenied(t *testing.T) {
+	s, conn := startServer(t, server.Options{
+		Classic: true,
+		Deny:    mustCIDRs(t, "192.0.2.0/24"),
+	})
+	defer s.Close()
+	req := stun.MustBuild(stun.BindingRequest, stun.ResponseAddress{IP: net.IPv4(192, 0, 2, 1), Port: 3478})
+	expectSilence(t, conn.LocalAddr(), req.Raw)
+	if got := s.Stats(); got != (server.Stats{Denied: 1}) {
+		t.Errorf("stats %+v", got)
+	}
+}
+
+func TestServerAmplification(t *testing.T) {
+	s, conn := startServer(t, server.Options{MaxAmplification: 1, Software: "cocostun"})
+	defer s.Close()
+	expectSilence(t, conn.LocalAddr(), stun.MustBuild(stun.BindingRequest).Raw)
+	if got := s.Stats(); got != (server.Stats{Oversized: 1}) {
+		t.Errorf("stats %+v", got)
+	}
+	//请求足够长时正常响应
+	req := stun.MustBuild(stun.BindingRequest, stun.Software(make([]byte, 128)))
+	roundTrip(t, conn.LocalAddr(), req.Raw)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets := mustCIDRs(t, "192.0.2.1", "2001:db8::/32")
+	if !nets[0].Contains(net.IPv4(192, 0, 2, 1)) || nets[0].Contains(net.IPv4(192, 0, 2, 2)) {
+		t.Errorf("single address: %v", nets[0])
+	}
+	if !nets[1].Contains(net.ParseIP("2001:db8::1")) {
+		t.Errorf("prefix: %v", nets[1])
+	}
+	if _, err := server.ParseCIDRs("not an ip"); err == nil {
+		t.Error("no error for bad address")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//可以通过Reload替换的配置,处理每个消息时取一次快照
+type config struct {
+	software      string
+	classic       bool
+	handler       Handler
+	acl           acl
+	limiter       *limiter // nil时不限流
+	amplification float64
+	idleTimeout   time.Duration
+	handshake     time.Duration
+	maxConns      int
+}
+
+//根据选项生成配置,限流参数不变时沿用prev的令牌桶
+func (s *Server) newConfig(o Options, prev *config) *config {
+	cfg := &config{
+		software:      o.Software,
+		classic:       o.Classic,
+		handler:       o.Handler,
+		acl:           acl{allow: o.Allow, deny: o.Deny},
+		amplification: o.MaxAmplification,
+		idleTimeout:   o.IdleTimeout,
+		handshake:     o.HandshakeTimeout,
+		maxConns:      o.MaxConnections,
+	}
+	if cfg.idleTimeout == 0 {
+		cfg.idleTimeout = DefaultIdleTimeout
+	}
+	if cfg.handshake == 0 {
+		cfg.handshake = DefaultHandshakeTimeout
+	}
+	if o.RateLimit > 0 {
+		cfg.limiter = newLimiter(o.RateLimit, o.RateBurst, s.clock)
+		if l := prev.rateLimiter(); l != nil && l.rate == cfg.limiter.rate && l.burst == cfg.limiter.burst {
+			cfg.limiter = l
+		}
+	}
+	if cfg.handler == nil {
+		mux := NewServeMux()
+		mux.Handle(stun.BindingRequest, Binding)
+		cfg.handler = mux
+	}
+	return cfg
+}
+
+func (cfg *config) rateLimiter() *limiter {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.limiter
+}
+
+func (s *Server) config() *config {
+	s.cfgMux.RLock()
+	defer s.cfgMux.RUnlock()
+	return s.cfg
+}
+
+//替换服务器配置,不影响正在监听的套接字和连接;
+//CacheSize、CacheBytes和Clock不能修改,正在处理的消息仍使用旧配置;
+//空闲超时在连接读取下一个消息时生效,最大连接数只影响新连接
+func (s *Server) Reload(o Options) {
+	s.cfgMux.Lock()
+	s.cfg = s.newConfig(o, s.cfg)
+	s.cfgMux.Unlock()
+}
@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+func software(t *testing.T, m *stun.Message) stun.Software {
+	t.Helper()
+	var s stun.Software
+	if err := s.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServerReload(t *testing.T) {
+	s, conn := startServer(t, server.Options{Software: "v1"})
+	defer s.Close()
+	addr := conn.LocalAddr()
+
+	res, _ := roundTrip(t, addr, stun.MustBuild(stun.BindingRequest).Raw)
+	if got := software(t, res); got != "v1" {
+		t.Fatalf("SOFTWARE %q, want v1", got)
+	}
+
+	s.Reload(server.Options{Software: "v2", Deny: mustCIDRs(t, "127.0.0.0/8")})
+	expectSilence(t, addr, stun.MustBuild(stun.BindingRequest).Raw)
+
+	s.Reload(server.Options{Software: "v2", RateLimit: 0.001, RateBurst: 1})
+	res, _ = roundTrip(t, addr, stun.MustBuild(stun.BindingRequest).Raw)
+	if got := software(t, res); got != "v2" {
+		t.Fatalf("SOFTWARE %q, want v2", got)
+	}
+	expectSilence(t, addr, stun.MustBuild(stun.BindingRequest).Raw)
+	//限流参数不变时令牌桶保留
+	s.Reload(server.Options{Software: "v3", RateLimit: 0.001, RateBurst: 1})
+	expectSilence(t, addr, stun.MustBuild(stun.BindingRequest).Raw)
+
+	//替换凭证
+	auth := func(password string) server.Options {
+		mux := server.NewServeMux()
+		mux.Handle(stun.BindingRequest, server.Binding)
+		creds := server.StaticCredentials{"alice": password}
+		return server.Options{Handler: server.Chain(mux, server.Authenticate(server.AuthOptions{Credentials: creds}))}
+	}
+	request := func(password string) *stun.Message {
+		res, _ := roundTrip(t, addr, mustBuild(t, stun.Username("alice"), stun.NewShortTermIntegrity(password), stun.Fingerprint))
+		return res
+	}
+	s.Reload(auth("old"))
+	if res = request("old"); res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+	s.Reload(auth("new"))
+	if code := errorCode(t, request("old")); code != stun.CodeUnauthorized {
+		t.Fatalf("got %d, want %d", code, stun.CodeUnauthorized)
+	}
+	if res = request("new"); res.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", res.Type)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	slow := server.HandlerFunc(func(w server.ResponseWriter, r *server.Request) {
+		started <- struct{}{}
+		time.Sleep(time.Millisecond * 200)
+		server.Binding.ServeSTUN(w, r)
+	})
+	s, conn := startServer(t, server.Options{Handler: slow})
+	client := listenUDP(t, "127.0.0.1:0")
+	defer client.Close()
+
+	req := stun.MustBuild(stun.BindingRequest)
+	if _, err := client.WriteTo(req.Raw, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//正在处理的请求仍然得到响应
+	if err := client.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.ReadFrom(make([]byte, 1500)); err != nil {
+		t.Fatalf("in-flight request is not answered: %v", err)
+	}
+	if _, err := conn.WriteTo(req.Raw, client.LocalAddr()); err == nil {
+		t.Error("socket is not closed after shutdown")
+	}
+	if err := s.Shutdown(ctx); err != server.ErrServerClosed {
+		t.Errorf("got %v, want %v", err, server.ErrServerClosed)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blocked := server.HandlerFunc(func(w server.ResponseWriter, r *server.Request) {
+		<-release
+	})
+	s, ln := startStreamServer(t, server.Options{Handler: blocked})
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(stun.MustBuild(stun.BindingRequest).Raw); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	expectClosed(t, conn, time.Second)
+}
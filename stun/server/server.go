@@ -0,0 +1,385 @@
+//STUN服务器,可嵌入到应用中在一个或多个net.PacketConn或TCP/TLS监听器上提供Binding服务
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+var (
+	ErrServerClosed = errors.New("server is closed")
+)
+
+//读缓冲区大小,UDP最大长度
+const readBufferSize = 65535
+
+//服务器选项,缓存容量和Clock以外的选项可以通过Reload修改
+type Options struct {
+	//SOFTWARE属性值,为空时不添加
+	Software string
+	//RFC 3489兼容模式,见classic.go
+	Classic bool
+	//请求处理,为空时只处理Binding请求
+	Handler Handler
+
+	//每个来源IP每秒最多处理的消息数,0不限制;RateBurst为允许的突发数,默认与RateLimit相同
+	RateLimit float64
+	RateBurst int
+	//响应长度最多为请求长度的倍数,超出时丢弃响应,0不限制
+	MaxAmplification float64
+	//来源和响应目的地址的访问控制,Deny优先,Allow不为空时只允许其中的地址
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+	//重传响应缓存的容量,默认DefaultCacheSize,小于0时不缓存
+	CacheSize int
+	//重传响应缓存的总字节数上限,默认DefaultCacheBytes
+	CacheBytes int
+	//TCP/TLS连接空闲超时,默认DefaultIdleTimeout,小于0时不超时
+	IdleTimeout time.Duration
+	//TLS握手超时,默认DefaultHandshakeTimeout,小于0时不超时
+	HandshakeTimeout time.Duration
+	//TCP/TLS最大连接数,0不限制
+	MaxConnections int
+	//默认SystemClock
+	Clock stun.Clock
+}
+
+type Server struct {
+	cfgMux sync.RWMutex // protects cfg
+	cfg    *config
+	clock  stun.Clock
+
+	cache *responseCache // nil时不缓存
+	stats serverStats
+
+	mux     sync.Mutex             // protects conns, streams and closed
+	conns   map[io.Closer]struct{} // PacketConn和Listener
+	streams map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func New(o Options) *Server {
+	s := &Server{
+		clock:   o.Clock,
+		conns:   make(map[io.Closer]struct{}),
+		streams: make(map[net.Conn]struct{}),
+	}
+	if s.clock == nil {
+		s.clock = stun.SystemClock
+	}
+	if o.CacheSize >= 0 {
+		size, maxBytes := o.CacheSize, o.CacheBytes
+		if size == 0 {
+			size = DefaultCacheSize
+		}
+		if maxBytes <= 0 {
+			maxBytes = DefaultCacheBytes
+		}
+		s.cache = newResponseCache(size, maxBytes, s.clock)
+	}
+	s.cfg = s.newConfig(o, nil)
+	return s
+}
+
+//监听地址并提供服务,直到出错或服务器关闭;network为tcp、tcp4或tcp6时使用TCP
+func ListenAndServe(network, address string, o Options) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			return err
+		}
+		return New(o).ServeListener(ln)
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	return New(o).Serve(conn)
+}
+
+//发送响应的连接,流连接只能写回对端
+type packetWriter interface {
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	LocalAddr() net.Addr
+}
+
+//服务的套接字或流连接,nat不为空时属于RFC 5780模式的四个套接字之一
+type listener struct {
+	conn   packetWriter
+	stream bool // TCP/TLS连接,客户端不重传
+	nat    *natConns
+	ip     int // nat中的下标
+	port   int
+}
+
+//响应从conn发送到addr
+type response struct {
+	conn packetWriter
+	addr net.Addr
+}
+
+//在conn上提供服务,直到conn出错或服务器关闭,可以在多个连接上同时调用;
+//服务器关闭时返回ErrServerClosed
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.serve(conn, &listener{conn: conn})
+}
+
+func (s *Server) serve(conn net.PacketConn, l *listener) error {
+	if !s.track(conn) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(conn)
+
+	buf := make([]byte, readBufferSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.handle(l, buf[:n], addr)
+	}
+}
+
+//立即关闭服务器及所有正在服务的套接字、监听器和连接,等待服务协程结束后返回
+func (s *Server) Close() error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	s.closeAll()
+	s.mux.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+//优雅关闭:关闭监听器不再接受连接,套接字和TCP/TLS连接处理完当前消息后停止读取,
+//全部结束后关闭套接字;ctx结束时强制关闭所有连接,不等待仍在执行的Handler,返回ctx的错误
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	now := time.Now()
+	var packets []net.PacketConn
+	for c := range s.conns {
+		if conn, ok := c.(net.PacketConn); ok {
+			conn.SetReadDeadline(now)
+			packets = append(packets, conn)
+			continue
+		}
+		c.Close()
+	}
+	for conn := range s.streams {
+		conn.SetReadDeadline(now)
+	}
+	s.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mux.Lock()
+		s.closeAll()
+		s.mux.Unlock()
+	}
+	for _, conn := range packets {
+		conn.Close()
+	}
+	return err
+}
+
+//关闭所有套接字、监听器和连接,调用时需持有s.mux
+func (s *Server) closeAll() {
+	for conn := range s.conns {
+		conn.Close()
+	}
+	for conn := range s.streams {
+		conn.Close()
+	}
+}
+
+func (s *Server) track(conn io.Closer) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn io.Closer) {
+	s.mux.Lock()
+	delete(s.conns, conn)
+	s.mux.Unlock()
+	s.wg.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closed
+}
+
+//处理一个数据报,默认从收到请求的连接回复到来源地址
+func (s *Server) handle(l *listener, b []byte, addr net.Addr) {
+	cfg := s.config()
+	if !s.allowSource(cfg, addr) {
+		return
+	}
+	req, err := cfg.parse(b)
+	if req == nil {
+		return
+	}
+	req.cfg = cfg
+	req.size = len(b)
+	req.Remote = addr
+	req.Local = l.conn.LocalAddr()
+	req.l = l
+	req.res = &response{conn: l.conn, addr: addr}
+	//流连接不重传,不占用缓存
+	if s.cache != nil && !l.stream && req.Type.Class == stun.ClassRequest {
+		key := newCacheKey(l.conn, addr, b)
+		//重传的请求返回相同的响应
+		if e, ok := s.cache.get(key); ok {
+			e.res.conn.WriteTo(e.raw, e.res.addr)
+			return
+		}
+		req.key = &key
+	}
+	w := &responseWriter{s: s, r: req}
+	if err != nil {
+		//头部合法但属性格式错误的请求返回400
+		if req.Type.Class == stun.ClassRequest {
+			w.WriteError(stun.CodeBadRequest)
+		}
+		return
+	}
+	//指纹错误的不是STUN消息
+	if err := req.CheckFingerprint(); err != nil && req.classic == nil {
+		return
+	}
+	cfg.handler.ServeSTUN(w, req)
+}
+
+//解析数据报,不是STUN消息时返回nil;头部合法但属性格式错误时返回只有头部的请求和错误
+func (cfg *config) parse(b []byte) (*Request, error) {
+	req := &Request{}
+	if !stun.IsMessage(b) {
+		if !cfg.classic || !isClassic(b) {
+			return nil, nil
+		}
+		b, req.classic = fromClassic(b)
+	}
+	req.Message = &stun.Message{Raw: b}
+	err := req.Decode()
+	if err == nil {
+		return req, nil
+	}
+	h := make([]byte, 20)
+	copy(h, b)
+	h[2], h[3] = 0, 0
+	req.Message = &stun.Message{Raw: h}
+	if req.Decode() != nil {
+		return nil, nil
+	}
+	return req, err
+}
+
+//构造响应,SOFTWARE在setters之前,以便setters最后可以是MESSAGE-INTEGRITY;
+//添加FINGERPRINT,RFC 3489请求的响应不带FINGERPRINT
+func (cfg *config) build(req *Request, typ stun.MessageType, setters ...stun.Setter) (*stun.Message, error) {
+	all := make([]stun.Setter, 0, len(setters)+4)
+	all = append(all, stun.NewTransactionIDSetter(req.TransactionID), typ)
+	if cfg.software != "" {
+		all = append(all, stun.Software(cfg.software))
+	}
+	all = append(all, setters...)
+	if req.classic == nil {
+		all = append(all, stun.Fingerprint)
+	}
+	res, err := stun.Build(all...)
+	if err != nil {
+		return nil, err
+	}
+	if req.classic != nil {
+		copy(res.Raw[4:8], req.classic)
+	}
+	return res, nil
+}
+
+//服务器能理解的必须理解属性(0x0000-0x7FFF)
+var knownAttributes = map[stun.AttrType]bool{
+	stun.AttrMappedAddress:    true,
+	stun.AttrUsername:         true,
+	stun.AttrMessageIntegrity: true,
+	stun.AttrErrorCode:        true,
+	stun.AttrRealm:            true,
+	stun.AttrNonce:            true,
+	stun.AttrXORMappedAddress: true,
+}
+
+//RFC 5780模式下额外支持的属性
+var natAttributes = map[stun.AttrType]bool{
+	stun.AttrChangeRequest: true,
+	stun.AttrResponsePort:  true,
+	stun.AttrPadding:       true,
+}
+
+func (cfg *config) unknownAttributes(m *stun.Message, nat bool) []stun.AttrType {
+	var unknown []stun.AttrType
+	for _, a := range m.Attributes {
+		known := knownAttributes[a.Type] || (nat && natAttributes[a.Type]) || (cfg.classic && classicAttributes[a.Type])
+		if a.Type < 0x8000 && !known {
+			unknown = append(unknown, a.Type)
+		}
+	}
+	return unknown
+}
+
+//地址的IP和端口
+func hostPort(addr net.Addr) (net.IP, int, bool) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port, true
+	case *net.TCPAddr:
+		return a.IP, a.Port, true
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil, 0, false
+	}
+	return ip, p, true
+}
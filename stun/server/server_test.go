@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+)
+
+//在回环地址上启动服务器
+func startServer(t *testing.T, o server.Options) (*server.Server, net.PacketConn) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(o)
+	go s.Serve(conn)
+	return s, conn
+}
+
+//发送原始数据并读取响应
+func roundTrip(t *testing.T, addr net.Addr, b []byte) (*stun.Message, net.Addr) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.WriteTo(b, addr); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(time.Second * 2)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &stun.Message{Raw: buf[:n]}
+	if err = res.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if err = res.CheckFingerprint(); err != nil {
+		t.Fatal(err)
+	}
+	return res, conn.LocalAddr()
+}
+
+func errorCode(t *testing.T, m *stun.Message) stun.ErrorCode {
+	t.Helper()
+	if m.Type.Class != stun.ClassErrorResponse {
+		t.Fatalf("got %v, want error response", m.Type)
+	}
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	return code.Code
+}
+
+func TestServerBinding(t *testing.T) {
+	s, conn := startServer(t, server.Options{Software: "cocostun test"})
+	defer s.Close()
+
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	res, local := roundTrip(t, conn.LocalAddr(), req.Raw)
+	if res.Type != stun.BindingSuccess || res.TransactionID != req.TransactionID {
+		t.Fatalf("unexpected response %v", res.Type)
+	}
+	var (
+		xor      stun.XORMappedAddress
+		mapped   stun.MappedAddress
+		software stun.Software
+	)
+	if err := xor.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapped.GetFrom(res); err != nil {
+		t.Fatal(err)
+	}
+	if err := software.GetFrom(res); err != nil || software != "cocostun test" {
+		t.Fatalf("software %q, %v", software, err)
+	}
+	if xor.String() != local.String() || mapped.String() != local.String() {
+		t.Fatalf("got %s and %s, want %s", xor, mapped, local)
+	}
+}
+
+func TestServerClient(t *testing.T) {
+	s, conn := startServer(t, server.Options{})
+	defer s.Close()
+	local, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stun.NewClient(local, conn.LocalAddr())
+	defer c.Close()
+
+	done := make(chan stun.AgentEvent, 1)
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*2), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := <-done
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	infos := e.Message.AsyncAttrbutes(local.LocalAddr().String())
+	if infos.MappedAddr == nil || infos.MappedAddr.String() != local.LocalAddr().String() {
+		t.Fatalf("mapped address %v, want %s", infos.MappedAddr, local.LocalAddr())
+	}
+}
+
+//SOFTWARE属性头中长度字段低字节的位置
+const messageAttrLengthOffset = 20 + 3
+
+func TestServerErrors(t *testing.T) {
+	s, conn := startServer(t, server.Options{})
+	defer s.Close()
+
+	//属性长度超出消息长度
+	malformed := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	malformed.Add(stun.AttrSoftware, []byte("abcd"))
+	malformed.Raw[messageAttrLengthOffset] = 0xff
+	res, _ := roundTrip(t, conn.LocalAddr(), malformed.Raw)
+	if code := errorCode(t, res); code != stun.CodeBadRequest {
+		t.Fatalf("malformed: got %d, want %d", code, stun.CodeBadRequest)
+	}
+
+	unknown := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	unknown.Add(stun.AttrType(0x7f01), []byte{1, 2, 3, 4})
+	res, _ = roundTrip(t, conn.LocalAddr(), unknown.Raw)
+	if code := errorCode(t, res); code != stun.CodeUnknownAttribute {
+		t.Fatalf("unknown attribute: got %d, want %d", code, stun.CodeUnknownAttribute)
+	}
+	var attrs stun.UnknownAttributes
+	if err := attrs.GetFrom(res); err != nil || len(attrs) != 1 || attrs[0] != 0x7f01 {
+		t.Fatalf("unknown attributes %v, %v", attrs, err)
+	}
+
+	method := stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest))
+	res, _ = roundTrip(t, conn.LocalAddr(), method.Raw)
+	if code := errorCode(t, res); code != stun.CodeBadRequest {
+		t.Fatalf("unknown method: got %d, want %d", code, stun.CodeBadRequest)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Options{})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(conn)
+	}()
+	time.Sleep(time.Millisecond * 20)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != server.ErrServerClosed {
+		t.Fatalf("got %v, want %v", err, server.ErrServerClosed)
+	}
+	if err = s.Serve(conn); err != server.ErrServerClosed {
+		t.Fatalf("got %v, want %v", err, server.ErrServerClosed)
+	}
+}
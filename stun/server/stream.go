@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//TCP/TLS连接默认空闲超时,RFC 5389 7.2.2要求至少10分钟
+const DefaultIdleTimeout = time.Minute * 10
+
+//TLS默认握手超时,避免慢速握手长期占用连接
+const DefaultHandshakeTimeout = time.Second * 10
+
+//Accept临时出错时的等待时间
+const acceptRetryDelay = time.Millisecond * 50
+
+//在流式监听器上提供服务(RFC 5389 7.2.2),TCP和TLS共用分帧,直到监听器出错或服务器关闭;
+//服务器关闭时返回ErrServerClosed
+func (s *Server) ServeListener(ln net.Listener) error {
+	if !s.track(ln) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(ln)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				time.Sleep(acceptRetryDelay)
+				continue
+			}
+			return err
+		}
+		if !s.config().acl.allowed(conn.RemoteAddr()) {
+			atomic.AddInt64(&s.stats.denied, 1)
+			conn.Close()
+			continue
+		}
+		if !s.trackStream(conn) {
+			conn.Close()
+			continue
+		}
+		go s.serveStream(conn)
+	}
+}
+
+//使用TLS在ln上提供服务(stuns:)
+func (s *Server) ServeTLS(ln net.Listener, config *tls.Config) error {
+	return s.ServeListener(tls.NewListener(ln, config))
+}
+
+//监听TCP地址并通过TLS提供服务,直到出错或服务器关闭
+func ListenAndServeTLS(network, address string, config *tls.Config, o Options) error {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return New(o).ServeTLS(ln, config)
+}
+
+func (s *Server) trackStream(conn net.Conn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return false
+	}
+	if max := s.config().maxConns; max > 0 && len(s.streams) >= max {
+		atomic.AddInt64(&s.stats.refused, 1)
+		return false
+	}
+	s.streams[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackStream(conn net.Conn) {
+	s.mux.Lock()
+	delete(s.streams, conn)
+	s.mux.Unlock()
+	s.wg.Done()
+}
+
+//设置下一帧的读超时,服务器关闭后不再读取
+func (s *Server) nextDeadline(conn net.Conn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return false
+	}
+	var deadline time.Time
+	if idle := s.config().idleTimeout; idle > 0 {
+		deadline = time.Now().Add(idle)
+	}
+	return conn.SetReadDeadline(deadline) == nil
+}
+
+//逐帧处理连接上的消息,空闲超时、出错或服务器关闭时关闭连接
+func (s *Server) serveStream(conn net.Conn) {
+	defer s.untrackStream(conn)
+	defer conn.Close()
+
+	if tc, ok := conn.(*tls.Conn); ok && !s.handshake(tc) {
+		return
+	}
+	l := &listener{conn: &streamConn{Conn: conn}, stream: true}
+	r := bufio.NewReader(conn)
+	buf := make([]byte, readBufferSize)
+	for s.nextDeadline(conn) {
+		n, err := stun.ReadFrame(r, buf)
+		if err != nil {
+			return
+		}
+		s.handle(l, buf[:n], conn.RemoteAddr())
+	}
+}
+
+//在握手超时内完成TLS握手
+func (s *Server) handshake(conn *tls.Conn) bool {
+	var deadline time.Time
+	if d := s.config().handshake; d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	if conn.SetDeadline(deadline) != nil {
+		return false
+	}
+	if conn.Handshake() != nil {
+		return false
+	}
+	return conn.SetDeadline(time.Time{}) == nil
+}
+
+//响应总是写回连接本身
+type streamConn struct {
+	net.Conn
+}
+
+func (c *streamConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
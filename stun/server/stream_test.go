@@ -0,0 +1,207 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/server"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+func startStreamServer(t *testing.T, o server.Options) (*server.Server, net.Listener) {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(o)
+	go s.ServeListener(ln)
+	return s, ln
+}
+
+//在流上发送请求并读取一帧响应
+func streamRoundTrip(t *testing.T, conn net.Conn, req *stun.Message) *stun.Message {
+	t.Helper()
+	if _, err := conn.Write(req.Raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second * 2)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, err := stun.ReadFrame(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &stun.Message{Raw: buf[:n]}
+	if err = res.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+//等待服务器关闭连接
+func expectClosed(t *testing.T, conn net.Conn, within time.Duration) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(within)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Fatalf("connection is not closed: %v", err)
+	}
+}
+
+func sendBinding(t *testing.T, c *stun.Client) stun.AgentEvent {
+	t.Helper()
+	done := make(chan stun.AgentEvent, 1)
+	if err := c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return <-done
+}
+
+func TestServerTCP(t *testing.T) {
+	s, ln := startStreamServer(t, server.Options{})
+	defer s.Close()
+	c, err := stun.DialTCP("tcp4", ln.Addr().String(), stun.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	e := sendBinding(t, c)
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if e.Message.Type != stun.BindingSuccess {
+		t.Fatalf("got %v, want success", e.Message.Type)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	cert, pool, err := stuntest.SelfSignedCert("stun.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Options{})
+	go s.ServeTLS(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer s.Close()
+
+	c, err := stun.DialTLS("tcp4", ln.Addr().String(), stun.TLSOptions{
+		ServerName: "stun.example.com",
+		RootCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if e := sendBinding(t, c); e.Error != nil {
+		t.Fatal(e.Error)
+	}
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	s, ln := startStreamServer(t, server.Options{MaxConnections: 1})
+	defer s.Close()
+	first, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	streamRoundTrip(t, first, stun.MustBuild(stun.BindingRequest))
+
+	second, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	expectClosed(t, second, time.Second*2)
+	if got := s.Stats(); got != (server.Stats{Refused: 1}) {
+		t.Errorf("stats %+v", got)
+	}
+	//第一个连接不受影响
+	streamRoundTrip(t, first, stun.MustBuild(stun.BindingRequest))
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	s, ln := startStreamServer(t, server.Options{IdleTimeout: time.Millisecond * 100})
+	defer s.Close()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	streamRoundTrip(t, conn, stun.MustBuild(stun.BindingRequest))
+	expectClosed(t, conn, time.Second*2)
+}
+
+func TestServerDrain(t *testing.T) {
+	s, ln := startStreamServer(t, server.Options{})
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	streamRoundTrip(t, conn, stun.MustBuild(stun.BindingRequest))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, conn, time.Second*2)
+	if _, err = net.Dial("tcp4", ln.Addr().String()); err == nil {
+		t.Error("listener is still accepting")
+	}
+}
+
+//TCP客户端不重传,相同事务id的请求每次都交给Handler
+func TestServerStreamNotCached(t *testing.T) {
+	var calls int64
+	s, ln := startStreamServer(t, server.Options{Handler: countingHandler(&calls)})
+	defer s.Close()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := stun.MustBuild(stun.BindingRequest)
+	streamRoundTrip(t, conn, req)
+	streamRoundTrip(t, conn, req)
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+}
+
+func TestServerTLSHandshakeTimeout(t *testing.T) {
+	cert, _, err := stuntest.SelfSignedCert("stun.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Options{HandshakeTimeout: time.Millisecond * 100})
+	go s.ServeTLS(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer s.Close()
+
+	//不发送ClientHello的连接在握手超时后被关闭
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectClosed(t, conn, time.Second*2)
+}
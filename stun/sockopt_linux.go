@@ -0,0 +1,46 @@
+//go:build linux
+
+package stun
+
+import (
+	"os"
+	"syscall"
+)
+
+func setSockopts(fd uintptr, network string, o DialOptions) error {
+	s := int(fd)
+	ipv6 := network == "udp6"
+	if o.ReuseAddr {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return os.NewSyscallError("setsockopt SO_REUSEADDR", err)
+		}
+	}
+	if o.ReusePort {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+			return os.NewSyscallError("setsockopt SO_REUSEPORT", err)
+		}
+	}
+	if o.TOS != 0 {
+		var err error
+		if ipv6 {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS)
+		} else {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS)
+		}
+		if err != nil {
+			return os.NewSyscallError("setsockopt TOS", err)
+		}
+	}
+	if o.DontFragment {
+		var err error
+		if ipv6 {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		} else {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		}
+		if err != nil {
+			return os.NewSyscallError("setsockopt MTU_DISCOVER", err)
+		}
+	}
+	return nil
+}
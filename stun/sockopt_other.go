@@ -0,0 +1,7 @@
+//go:build !linux
+
+package stun
+
+func setSockopts(fd uintptr, network string, o DialOptions) error {
+	return ErrSockoptUnsupported
+}
@@ -0,0 +1,151 @@
+package stun
+
+import (
+	"expvar"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//RTT直方图区间上限
+var rttBuckets = []time.Duration{
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+}
+
+//客户端统计数据
+type clientStats struct {
+	inFlight       int64
+	transactions   int64
+	responses      int64
+	timeouts       int64
+	retransmits    int64
+	decodeFailures int64
+	mismatches     int64
+	truncated      int64
+
+	mux sync.Mutex // protects rtt
+	rtt map[string]*RTTHistogram
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{
+		rtt: make(map[string]*RTTHistogram),
+	}
+}
+
+func (s *clientStats) observeRTT(addr net.Addr, rtt time.Duration) {
+	key := addrKey(addr)
+	s.mux.Lock()
+	h, ok := s.rtt[key]
+	if !ok {
+		h = newRTTHistogram()
+		s.rtt[key] = h
+	}
+	h.observe(rtt)
+	s.mux.Unlock()
+}
+
+//统计快照,字段可直接序列化为JSON
+type Stats struct {
+	InFlight       int64
+	Transactions   int64
+	Responses      int64
+	Timeouts       int64
+	Retransmits    int64
+	DecodeFailures int64
+	Mismatches     int64                   // 来源地址、类型或完整性校验不匹配而丢弃的响应
+	Truncated      int64                   // 超过读缓冲区或头部长度大于实际数据的数据包
+	RTT            map[string]RTTHistogram // 按服务器地址统计
+}
+
+//RTT直方图,Buckets按区间上限计数,超过最大区间的计入"+Inf"
+type RTTHistogram struct {
+	Count   int64
+	Sum     time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Buckets map[string]int64
+}
+
+func newRTTHistogram() *RTTHistogram {
+	h := &RTTHistogram{
+		Buckets: make(map[string]int64, len(rttBuckets)+1),
+	}
+	for _, b := range rttBuckets {
+		h.Buckets[b.String()] = 0
+	}
+	h.Buckets["+Inf"] = 0
+	return h
+}
+
+func (h *RTTHistogram) observe(rtt time.Duration) {
+	if h.Count == 0 || rtt < h.Min {
+		h.Min = rtt
+	}
+	if rtt > h.Max {
+		h.Max = rtt
+	}
+	h.Count++
+	h.Sum += rtt
+	for _, b := range rttBuckets {
+		if rtt <= b {
+			h.Buckets[b.String()]++
+			return
+		}
+	}
+	h.Buckets["+Inf"]++
+}
+
+//平均RTT
+func (h RTTHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+func (h *RTTHistogram) clone() RTTHistogram {
+	c := *h
+	c.Buckets = make(map[string]int64, len(h.Buckets))
+	for k, v := range h.Buckets {
+		c.Buckets[k] = v
+	}
+	return c
+}
+
+//返回客户端统计快照
+func (c *Client) Stats() Stats {
+	s := c.stats
+	st := Stats{
+		InFlight:       atomic.LoadInt64(&s.inFlight),
+		Transactions:   atomic.LoadInt64(&s.transactions),
+		Responses:      atomic.LoadInt64(&s.responses),
+		Timeouts:       atomic.LoadInt64(&s.timeouts),
+		Retransmits:    atomic.LoadInt64(&s.retransmits),
+		DecodeFailures: atomic.LoadInt64(&s.decodeFailures),
+		Mismatches:     atomic.LoadInt64(&s.mismatches),
+		Truncated:      atomic.LoadInt64(&s.truncated),
+	}
+	s.mux.Lock()
+	st.RTT = make(map[string]RTTHistogram, len(s.rtt))
+	for k, h := range s.rtt {
+		st.RTT[k] = h.clone()
+	}
+	s.mux.Unlock()
+	return st
+}
+
+//通过expvar导出统计数据,名称重复时与expvar.Publish一样会panic
+func (c *Client) PublishStats(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+}
@@ -0,0 +1,334 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidFrame = errors.New("invalid stream frame")
+)
+
+const (
+	//重连等待时间,每次失败翻倍
+	minReconnectDelay = time.Millisecond * 100
+	maxReconnectDelay = time.Second * 5
+
+	defaultDialTimeout = time.Second * 5
+)
+
+//从流中读取一帧(STUN消息或ChannelData),帧长度超过len(b)时只返回前len(b)字节,
+//剩余部分被丢弃,与UDP截断的行为一致
+//
+//ChannelData在流上需要4字节对齐(RFC 5766 11.5)
+func ReadFrame(r io.Reader, b []byte) (int, error) {
+	var h [channelDataHeaderSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, err
+	}
+	var size int
+	switch f := h[0]; {
+	case f&0xc0 == 0:
+		//STUN消息头20字节,前4字节已读取
+		size = messageHeaderSize + int(bin.Uint16(h[2:4]))
+	case f >= 64 && f <= 79:
+		size = channelDataHeaderSize + nearestPaddedValueLength(int(bin.Uint16(h[2:4])))
+	default:
+		return 0, ErrInvalidFrame
+	}
+	n := copy(b, h[:])
+	if size <= len(b) {
+		if _, err := io.ReadFull(r, b[n:size]); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+	if _, err := io.ReadFull(r, b[n:]); err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size-len(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+//流式拨号选项
+type StreamOptions struct {
+	//本地地址,为空时由系统分配
+	LocalAddr *net.TCPAddr
+	//拨号超时,默认5秒
+	Timeout time.Duration
+
+	//客户端选项,Connection和ServerAddr由拨号填充,流式传输不重传
+	Client ClientOptions
+}
+
+//通过TCP连接STUN服务器,network为tcp、tcp4或tcp6,连接断开后自动重连
+func DialTCP(network, address string, o StreamOptions) (*Client, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	dial := o.tcpDialer(network)
+	return dialStream(o, dialAny([]string{address}, dial), func(addr, domain string) dialFunc {
+		return dialAny([]string{addr}, dial)
+	})
+}
+
+func (o StreamOptions) tcpDialer(network string) func(ctx context.Context, addr string) (net.Conn, error) {
+	d := o.dialer()
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+func (o StreamOptions) dialer() *net.Dialer {
+	d := &net.Dialer{
+		Timeout: o.Timeout,
+	}
+	if d.Timeout == 0 {
+		d.Timeout = defaultDialTimeout
+	}
+	if o.LocalAddr != nil {
+		d.LocalAddr = o.LocalAddr
+	}
+	return d
+}
+
+//建立流连接,ctx取消时中止拨号
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
+//先同步建立连接,保证地址错误等问题在拨号时返回,
+//redial根据备用服务器地址和域名返回新的拨号函数,用于ALTERNATE-SERVER重定向
+func dialStream(o StreamOptions, dial dialFunc, redial func(addr, domain string) dialFunc) (*Client, error) {
+	conn, err := dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	p := newStreamConn(conn, dial, o.Client.Clock)
+	p.redial = redial
+	options := o.Client
+	options.Connection = p
+	options.ServerAddr = p.remote
+	options.RTO = 0
+	c := NewClientWithOptions(options)
+	p.setOnRemote(func(addr net.Addr) {
+		c.addrMux.Lock()
+		c.serAddr = addr
+		c.addrMux.Unlock()
+	})
+	return c, nil
+}
+
+//基于流的net.PacketConn,每次ReadFrom读取一帧,连接断开后自动重连,
+//所有数据都来自同一个服务器
+type streamConn struct {
+	redial func(addr, domain string) dialFunc
+	local  net.Addr
+	clock  Clock // 重连等待计时
+	done   chan struct{}
+	ctx    context.Context // Close时取消,中止正在进行的拨号
+	cancel context.CancelFunc
+
+	mux      sync.Mutex // protects dial, remote, onRemote, conn and closed
+	dial     dialFunc
+	remote   net.Addr
+	onRemote func(addr net.Addr) // 重连到其他地址时通知客户端
+	conn     net.Conn
+	closed   bool
+}
+
+func newStreamConn(conn net.Conn, dial dialFunc, clock Clock) *streamConn {
+	if clock == nil {
+		clock = SystemClock
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamConn{
+		dial:   dial,
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+		clock:  clock,
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		conn:   conn,
+	}
+}
+
+func (p *streamConn) setOnRemote(f func(addr net.Addr)) {
+	p.mux.Lock()
+	p.onRemote = f
+	p.mux.Unlock()
+}
+
+//返回当前连接,连接不存在时重新拨号,
+//拨号时不持有锁,Close会中止正在进行的拨号
+func (p *streamConn) getConn() (net.Conn, error) {
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		return nil, ErrClientClosed
+	}
+	if p.conn != nil {
+		conn := p.conn
+		p.mux.Unlock()
+		return conn, nil
+	}
+	dial := p.dial
+	p.mux.Unlock()
+
+	conn, err := dial(p.ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		conn.Close()
+		return nil, ErrClientClosed
+	}
+	//拨号期间其他调用已经建立了连接或发生了重定向
+	if p.conn != nil {
+		current := p.conn
+		p.mux.Unlock()
+		conn.Close()
+		return current, nil
+	}
+	p.conn = conn
+	//有多个解析地址时可能连到了另一个服务器
+	var notify func(addr net.Addr)
+	if remote := conn.RemoteAddr(); !sameAddr(p.remote, remote) {
+		p.remote = remote
+		notify = p.onRemote
+	}
+	p.mux.Unlock()
+	if notify != nil {
+		notify(conn.RemoteAddr())
+	}
+	return conn, nil
+}
+
+//丢弃出错的连接,返回是否为当前连接
+func (p *streamConn) drop(conn net.Conn) bool {
+	p.mux.Lock()
+	current := p.conn == conn
+	if current {
+		p.conn = nil
+	}
+	p.mux.Unlock()
+	conn.Close()
+	return current
+}
+
+//重定向到备用服务器,之后的读写和重连都使用新连接
+func (p *streamConn) Redirect(addr net.Addr, domain string) error {
+	if p.redial == nil {
+		return ErrUnsupportedURIDial
+	}
+	dial := p.redial(addr.String(), domain)
+	conn, err := dial(p.ctx)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	old := p.conn
+	p.dial = dial
+	p.remote = conn.RemoteAddr()
+	p.conn = conn
+	p.mux.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (p *streamConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	delay := minReconnectDelay
+	for {
+		conn, err := p.getConn()
+		if err == ErrClientClosed {
+			return 0, nil, err
+		}
+		if err == nil {
+			n, rErr := ReadFrame(conn, b)
+			if rErr == nil {
+				return n, conn.RemoteAddr(), nil
+			}
+			//被重定向替换的连接不用等待
+			if !p.drop(conn) {
+				continue
+			}
+		}
+		//连接断开或拨号失败,等待后重连
+		wait := make(chan struct{})
+		t := p.clock.AfterFunc(delay, func() {
+			close(wait)
+		})
+		select {
+		case <-p.done:
+			t.Stop()
+			return 0, nil, ErrClientClosed
+		case <-wait:
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+//流已连接到服务器,忽略addr
+func (p *streamConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	conn, err := p.getConn()
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Write(b)
+	if err != nil {
+		p.drop(conn)
+	}
+	return n, err
+}
+
+func (p *streamConn) Close() error {
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		return ErrClientClosed
+	}
+	p.closed = true
+	conn := p.conn
+	p.conn = nil
+	p.mux.Unlock()
+	p.cancel()
+	close(p.done)
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (p *streamConn) LocalAddr() net.Addr {
+	return p.local
+}
+
+func (p *streamConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *streamConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,145 @@
+package stun_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+func TestReadFrame(t *testing.T) {
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	m.AddSoftwareAttribute("test")
+	channelData := []byte{0x40, 0x00, 0x00, 0x03, 'a', 'b', 'c', 0}
+	stream := bytes.NewReader(append(append(append([]byte(nil), m.Raw...), channelData...), m.Raw...))
+
+	buf := make([]byte, 1500)
+	n, err := stun.ReadFrame(stream, buf)
+	if err != nil || !bytes.Equal(buf[:n], m.Raw) {
+		t.Fatalf("got %x, %v, want %x", buf[:n], err, m.Raw)
+	}
+	n, err = stun.ReadFrame(stream, buf)
+	if err != nil || !bytes.Equal(buf[:n], channelData) {
+		t.Fatalf("got %x, %v, want %x", buf[:n], err, channelData)
+	}
+	//缓冲区不足时截断,剩余数据被丢弃
+	n, err = stun.ReadFrame(stream, buf[:24])
+	if err != nil || n != 24 || stream.Len() != 0 {
+		t.Fatalf("got %d, %v, %d bytes left", n, err, stream.Len())
+	}
+	if _, err = stun.ReadFrame(bytes.NewReader([]byte{0xff, 0, 0, 0}), buf); err != stun.ErrInvalidFrame {
+		t.Fatalf("got %v, want %v", err, stun.ErrInvalidFrame)
+	}
+}
+
+//STUN over TCP测试服务器,每个连接回复requests个请求后关闭连接
+func serveStream(t *testing.T, l net.Listener, requests int) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			buf := make([]byte, 1500)
+			for i := 0; i < requests; i++ {
+				n, err := stun.ReadFrame(conn, buf)
+				if err != nil {
+					return
+				}
+				req := &stun.Message{Raw: buf[:n]}
+				if err = req.Decode(); err != nil {
+					t.Error(err)
+					return
+				}
+				res := stun.MustBuild(stun.BindingSuccess)
+				res.TransactionID = req.TransactionID
+				res.WriteTransactionID()
+				if _, err = conn.Write(res.Raw); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveStream(t, l, 1)
+
+	c, err := stun.DialTCP("tcp4", l.Addr().String(), stun.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	//服务器每个连接只回复一次,第二次请求需要重连
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			//等待客户端发现连接被关闭
+			time.Sleep(time.Millisecond * 200)
+		}
+		done := make(chan stun.AgentEvent, 1)
+		m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if err = c.SendMessage(m, time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+			done <- e
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if e := <-done; e.Error != nil {
+			t.Fatalf("request %d: %v", i, e.Error)
+		}
+	}
+}
+
+//重连等待使用客户端的Clock
+func TestDialTCPReconnectClock(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	clock := stuntest.NewFakeClock(time.Now())
+	c, err := stun.DialTCP("tcp4", l.Addr().String(), stun.StreamOptions{
+		Client: stun.ClientOptions{Clock: clock},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	first := <-accepted
+	first.Close()
+
+	//时钟不前进时不重连
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		t.Fatal("reconnected before backoff elapsed")
+	case <-time.After(time.Millisecond * 300):
+	}
+	clock.Advance(time.Second)
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second * 2):
+		t.Fatal("not reconnected after backoff")
+	}
+}
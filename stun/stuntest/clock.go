@@ -0,0 +1,151 @@
+//测试辅助工具
+package stuntest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+//假时钟,只有调用Advance时时间才会前进并触发到期的定时器
+type FakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+//时间前进d,按到期顺序触发定时器,AfterFunc的回调在当前协程同步执行
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	target := c.now.Add(d)
+	c.mux.Unlock()
+	for {
+		c.mux.Lock()
+		t := c.next(target)
+		if t == nil {
+			c.now = target
+			c.mux.Unlock()
+			return
+		}
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			c.remove(t)
+		}
+		c.mux.Unlock()
+		t.fire(c.now)
+	}
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) stun.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{
+		c:      c,
+		period: d,
+		ch:     make(chan time.Time, 1),
+	}
+	c.add(t, d)
+	return fakeTicker{t}
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) stun.Timer {
+	t := &fakeTimer{
+		c: c,
+		f: f,
+	}
+	c.add(t, d)
+	return t
+}
+
+//已注册且未停止的定时器数量
+func (c *FakeClock) Timers() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.timers)
+}
+
+func (c *FakeClock) add(t *fakeTimer, d time.Duration) {
+	c.mux.Lock()
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.mux.Unlock()
+}
+
+//取出最早到期且不晚于target的定时器,调用方持有锁
+func (c *FakeClock) next(target time.Time) *fakeTimer {
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+	if len(c.timers) == 0 || c.timers[0].when.After(target) {
+		return nil
+	}
+	return c.timers[0]
+}
+
+//删除定时器,调用方持有锁
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, candidate := range c.timers {
+		if candidate == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	c      *FakeClock
+	when   time.Time
+	period time.Duration
+	f      func()
+	ch     chan time.Time
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		t.f()
+		return
+	}
+	//与time.Ticker不同,缓冲已满时用最新时间替换旧值,保证读到的是最后一次tick
+	select {
+	case <-t.ch:
+	default:
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mux.Lock()
+	defer t.c.mux.Unlock()
+	return t.c.remove(t)
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.t.ch
+}
+
+func (t fakeTicker) Stop() {
+	t.t.Stop()
+}
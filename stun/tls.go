@@ -0,0 +1,74 @@
+package stun
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+//TLS拨号选项
+type TLSOptions struct {
+	StreamOptions
+
+	//SNI及证书校验使用的主机名,默认取address中的主机名
+	ServerName string
+	//根证书,为空时使用系统根证书
+	RootCAs *x509.CertPool
+	//客户端证书
+	Certificates []tls.Certificate
+	//跳过证书校验,仅用于测试
+	InsecureSkipVerify bool
+}
+
+func (o TLSOptions) config(address string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         o.ServerName,
+		RootCAs:            o.RootCAs,
+		Certificates:       o.Certificates,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	return cfg, nil
+}
+
+//通过TLS连接STUN服务器(stuns:),与TCP共用流式分帧,连接断开后自动重连
+func DialTLS(network, address string, o TLSOptions) (*Client, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	cfg, err := o.config(address)
+	if err != nil {
+		return nil, err
+	}
+	return dialStream(o.StreamOptions, dialAny([]string{address}, o.tlsDialer(network, cfg)), o.tlsRedialer(network, cfg))
+}
+
+//重定向时用ALTERNATE-DOMAIN校验备用服务器证书,没有该属性时沿用原服务器名
+func (o TLSOptions) tlsRedialer(network string, cfg *tls.Config) func(addr, domain string) dialFunc {
+	return func(addr, domain string) dialFunc {
+		c := cfg.Clone()
+		if domain != "" {
+			c.ServerName = domain
+		}
+		return dialAny([]string{addr}, o.tlsDialer(network, c))
+	}
+}
+
+func (o TLSOptions) tlsDialer(network string, cfg *tls.Config) func(ctx context.Context, addr string) (net.Conn, error) {
+	d := &tls.Dialer{
+		NetDialer: o.dialer(),
+		Config:    cfg,
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, network, addr)
+	}
+}
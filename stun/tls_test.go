@@ -0,0 +1,115 @@
+package stun_test
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+	"github.com/cocobao/cocostun/stun/stuntest"
+)
+
+func TestDialTLS(t *testing.T) {
+	serverCert, serverPool, err := stuntest.SelfSignedCert("stun.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, clientPool, err := stuntest.SelfSignedCert("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveStream(t, l, 1)
+
+	o := stun.TLSOptions{
+		ServerName:   "stun.example.com",
+		RootCAs:      serverPool,
+		Certificates: []tls.Certificate{clientCert},
+	}
+	c, err := stun.DialTLS("tcp4", l.Addr().String(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	done := make(chan stun.AgentEvent, 1)
+	m := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err = c.SendMessage(m, time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-done; e.Error != nil {
+		t.Fatal(e.Error)
+	}
+
+	//SNI与证书不匹配时拨号失败
+	o.ServerName = "other.example.com"
+	if c, err = stun.DialTLS("tcp4", l.Addr().String(), o); err == nil {
+		c.Close()
+		t.Fatal("expected certificate error")
+	}
+}
+
+func TestDialTLSCloseWhileDialing(t *testing.T) {
+	cert, pool, err := stuntest.SelfSignedCert("stun.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	//第一个连接完成握手后立即关闭,之后的连接不握手,重连会一直等待
+	go func() {
+		var held []net.Conn
+		defer func() {
+			for _, conn := range held {
+				conn.Close()
+			}
+		}()
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if i > 0 {
+				held = append(held, conn)
+				continue
+			}
+			tc := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+			tc.Handshake()
+			tc.Close()
+		}
+	}()
+
+	o := stun.TLSOptions{
+		ServerName: "stun.example.com",
+		RootCAs:    pool,
+	}
+	o.Timeout = time.Second * 30
+	c, err := stun.DialTLS("tcp4", l.Addr().String(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//等待客户端发现连接被关闭并开始重连
+	time.Sleep(time.Millisecond * 300)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close blocked by dial")
+	}
+}
@@ -0,0 +1,256 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownScheme      = errors.New("unknown uri scheme")
+	ErrInvalidHost        = errors.New("invalid uri host")
+	ErrInvalidPort        = errors.New("invalid uri port")
+	ErrInvalidQuery       = errors.New("invalid uri query")
+	ErrUnsupportedURIDial = errors.New("uri transport is not supported")
+)
+
+//URI协议
+const (
+	SchemeSTUN  = "stun"
+	SchemeSTUNS = "stuns"
+	SchemeTURN  = "turn"
+	SchemeTURNS = "turns"
+)
+
+//URI传输方式
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+)
+
+const (
+	DefaultPort    = 3478
+	DefaultTLSPort = 5349
+)
+
+//STUN/TURN URI (RFC 7064/7065)
+//
+//	stunURI = scheme ":" host [ ":" port ]
+//	turnURI = scheme ":" host [ ":" port ] [ "?transport=" transport ]
+type URI struct {
+	Scheme    string
+	Host      string // IPv6地址不带方括号
+	Port      int
+	Transport string
+	//URI中指定了端口,为false时Port是默认端口,解析时查询SRV记录
+	ExplicitPort bool
+}
+
+//解析URI,未指定时填充默认端口和传输方式
+func ParseURI(raw string) (*URI, error) {
+	i := strings.IndexByte(raw, ':')
+	if i < 0 {
+		return nil, ErrUnknownScheme
+	}
+	u := &URI{
+		Scheme: strings.ToLower(raw[:i]),
+	}
+	rest := raw[i+1:]
+	switch u.Scheme {
+	case SchemeSTUN, SchemeSTUNS, SchemeTURN, SchemeTURNS:
+	default:
+		return nil, ErrUnknownScheme
+	}
+
+	//只有TURN URI可以带transport参数
+	if q := strings.IndexByte(rest, '?'); q >= 0 {
+		if !u.IsTURN() {
+			return nil, ErrInvalidQuery
+		}
+		query := rest[q+1:]
+		rest = rest[:q]
+		if !strings.HasPrefix(query, "transport=") {
+			return nil, ErrInvalidQuery
+		}
+		u.Transport = strings.ToLower(strings.TrimPrefix(query, "transport="))
+		if u.Transport != TransportUDP && u.Transport != TransportTCP {
+			return nil, ErrInvalidQuery
+		}
+	}
+	if u.Transport == "" {
+		u.Transport = TransportUDP
+		if u.Secure() {
+			u.Transport = TransportTCP
+		}
+	}
+
+	host, port, err := splitURIHostPort(rest)
+	if err != nil {
+		return nil, err
+	}
+	u.Host = host
+	u.Port = port
+	u.ExplicitPort = port != 0
+	if u.Port == 0 {
+		u.Port = DefaultPort
+		if u.Secure() {
+			u.Port = DefaultTLSPort
+		}
+	}
+	return u, nil
+}
+
+//拆分host和port,port为空时返回0
+func splitURIHostPort(s string) (string, int, error) {
+	var host, port string
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return "", 0, ErrInvalidHost
+		}
+		host = s[1:end]
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return "", 0, ErrInvalidHost
+		}
+		s = s[end+1:]
+		if s != "" {
+			if s[0] != ':' {
+				return "", 0, ErrInvalidHost
+			}
+			port = s[1:]
+			if port == "" {
+				return "", 0, ErrInvalidPort
+			}
+		}
+	} else {
+		host = s
+		if i := strings.IndexByte(s, ':'); i >= 0 {
+			host, port = s[:i], s[i+1:]
+			if port == "" {
+				return "", 0, ErrInvalidPort
+			}
+		}
+		if !validHostname(host) {
+			return "", 0, ErrInvalidHost
+		}
+	}
+	if port == "" {
+		return host, 0, nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return "", 0, ErrInvalidPort
+	}
+	return host, p, nil
+}
+
+//IPv4地址或域名
+func validHostname(h string) bool {
+	if h == "" || len(h) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(h, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//是否为TLS协议
+func (u *URI) Secure() bool {
+	return u.Scheme == SchemeSTUNS || u.Scheme == SchemeTURNS
+}
+
+func (u *URI) IsTURN() bool {
+	return u.Scheme == SchemeTURN || u.Scheme == SchemeTURNS
+}
+
+//host:port形式的地址
+func (u *URI) Addr() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+func (u *URI) String() string {
+	host := u.Host
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	s := fmt.Sprintf("%s:%s:%d", u.Scheme, host, u.Port)
+	if u.IsTURN() {
+		s += "?transport=" + u.Transport
+	}
+	return s
+}
+
+//按URI拨号的选项
+type URIOptions struct {
+	//客户端选项,覆盖Dial和Stream中的Client字段
+	Client ClientOptions
+	//UDP拨号选项
+	Dial DialOptions
+	//TCP和TLS拨号选项,TLS相关字段只在stuns/turns时使用
+	Stream TLSOptions
+	//DNS解析,默认net.DefaultResolver
+	Resolver Resolver
+}
+
+//根据URI的协议和传输方式选择UDP、TCP或TLS客户端,
+//域名通过ResolveURI解析,UDP事务超时后切换到下一个地址,TCP和TLS按顺序尝试连接
+func DialURI(u *URI, o URIOptions) (*Client, error) {
+	if u.Transport == TransportUDP && u.Secure() {
+		//DTLS(turns:?transport=udp)暂不支持
+		return nil, ErrUnsupportedURIDial
+	}
+	addrs, err := ResolveURI(context.Background(), o.Resolver, u)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Transport == TransportUDP:
+		servers := make([]net.Addr, 0, len(addrs))
+		for _, a := range addrs {
+			addr, err := net.ResolveUDPAddr("udp", a)
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, addr)
+		}
+		do := o.Dial
+		do.Client = o.Client
+		c, err := DialWithOptions("udp", servers[0].(*net.UDPAddr), do)
+		if err != nil {
+			return nil, err
+		}
+		c.SetServers(servers)
+		return c, nil
+	case !u.Secure():
+		so := o.Stream.StreamOptions
+		so.Client = o.Client
+		dial := so.tcpDialer("tcp")
+		return dialStream(so, dialAny(addrs, dial), func(addr, domain string) dialFunc {
+			return dialAny([]string{addr}, dial)
+		})
+	default:
+		to := o.Stream
+		to.Client = o.Client
+		if to.ServerName == "" {
+			to.ServerName = u.Host
+		}
+		cfg, err := to.config(u.Addr())
+		if err != nil {
+			return nil, err
+		}
+		return dialStream(to.StreamOptions, dialAny(addrs, to.tlsDialer("tcp", cfg)), to.tlsRedialer("tcp", cfg))
+	}
+}
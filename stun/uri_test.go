@@ -0,0 +1,153 @@
+package stun_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cocobao/cocostun/stun"
+)
+
+func TestParseURI(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		uri stun.URI
+		err error
+	}{
+		{"stun:example.org", stun.URI{Scheme: "stun", Host: "example.org", Port: 3478, Transport: "udp"}, nil},
+		{"STUN:example.org:3479", stun.URI{Scheme: "stun", Host: "example.org", Port: 3479, Transport: "udp", ExplicitPort: true}, nil},
+		{"stuns:example.org", stun.URI{Scheme: "stuns", Host: "example.org", Port: 5349, Transport: "tcp"}, nil},
+		{"stun:[2001:db8::1]:1000", stun.URI{Scheme: "stun", Host: "2001:db8::1", Port: 1000, Transport: "udp", ExplicitPort: true}, nil},
+		{"stun:[::1]", stun.URI{Scheme: "stun", Host: "::1", Port: 3478, Transport: "udp"}, nil},
+		{"turn:127.0.0.1?transport=tcp", stun.URI{Scheme: "turn", Host: "127.0.0.1", Port: 3478, Transport: "tcp"}, nil},
+		{"turns:example.org:443?transport=tcp", stun.URI{Scheme: "turns", Host: "example.org", Port: 443, Transport: "tcp", ExplicitPort: true}, nil},
+		{"turn:example.org", stun.URI{Scheme: "turn", Host: "example.org", Port: 3478, Transport: "udp"}, nil},
+		{"http:example.org", stun.URI{}, stun.ErrUnknownScheme},
+		{"example.org", stun.URI{}, stun.ErrUnknownScheme},
+		{"stun://example.org", stun.URI{}, stun.ErrInvalidHost},
+		{"stun:", stun.URI{}, stun.ErrInvalidHost},
+		{"stun:[::1", stun.URI{}, stun.ErrInvalidHost},
+		{"stun:[127.0.0.1]", stun.URI{}, stun.ErrInvalidHost},
+		{"stun:example.org:", stun.URI{}, stun.ErrInvalidPort},
+		{"stun:example.org:70000", stun.URI{}, stun.ErrInvalidPort},
+		{"stun:example.org?transport=udp", stun.URI{}, stun.ErrInvalidQuery},
+		{"turn:example.org?transport=sctp", stun.URI{}, stun.ErrInvalidQuery},
+	} {
+		u, err := stun.ParseURI(tc.raw)
+		if err != tc.err {
+			t.Errorf("%s: got error %v, want %v", tc.raw, err, tc.err)
+			continue
+		}
+		if err == nil && *u != tc.uri {
+			t.Errorf("%s: got %+v, want %+v", tc.raw, *u, tc.uri)
+		}
+	}
+}
+
+func TestURIString(t *testing.T) {
+	for raw, want := range map[string]string{
+		"stun:[::1]":             "stun:[::1]:3478",
+		"turns:example.org":      "turns:example.org:5349?transport=tcp",
+		"stuns:example.org:5350": "stuns:example.org:5350",
+	} {
+		u, err := stun.ParseURI(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := u.String(); s != want {
+			t.Errorf("%s: got %s, want %s", raw, s, want)
+		}
+	}
+}
+
+func TestDialURI(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveStream(t, l, 1)
+
+	port := l.Addr().(*net.TCPAddr).Port
+	u, err := stun.ParseURI("turn:127.0.0.1:" + strconv.Itoa(port) + "?transport=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := stun.DialURI(u, stun.URIOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	done := make(chan stun.AgentEvent, 1)
+	if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*5), func(e stun.AgentEvent) {
+		done <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-done; e.Error != nil {
+		t.Fatal(e.Error)
+	}
+
+	u, _ = stun.ParseURI("turns:127.0.0.1?transport=udp")
+	if _, err = stun.DialURI(u, stun.URIOptions{}); err != stun.ErrUnsupportedURIDial {
+		t.Fatalf("got %v, want %v", err, stun.ErrUnsupportedURIDial)
+	}
+}
+
+func TestDialURIReconnectOtherTarget(t *testing.T) {
+	first, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	go serveStream(t, second, 1)
+	//第一个服务器回复一次后停止监听,重连只能连到第二个
+	go serveStream(t, first, 1)
+
+	r := stubResolver{
+		srv: map[string][]*net.SRV{
+			"_turn._tcp.example.org": {
+				{Target: "a.example.org", Port: uint16(first.Addr().(*net.TCPAddr).Port), Priority: 10},
+				{Target: "b.example.org", Port: uint16(second.Addr().(*net.TCPAddr).Port), Priority: 20},
+			},
+		},
+		ip: map[string][]net.IPAddr{
+			"a.example.org": ipAddrs("127.0.0.1"),
+			"b.example.org": ipAddrs("127.0.0.1"),
+		},
+	}
+	u, err := stun.ParseURI("turn:example.org?transport=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := stun.DialURI(u, stun.URIOptions{Resolver: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			first.Close()
+			//等待客户端发现连接被关闭并重连
+			time.Sleep(time.Millisecond * 300)
+		}
+		done := make(chan stun.AgentEvent, 1)
+		if err = c.SendMessage(stun.MustBuild(stun.TransactionID, stun.BindingRequest), time.Now().Add(time.Second*2), func(e stun.AgentEvent) {
+			done <- e
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if e := <-done; e.Error != nil {
+			t.Fatalf("request %d: %v", i, e.Error)
+		}
+	}
+	if got, want := c.ServerAddr().String(), second.Addr().String(); got != want {
+		t.Fatalf("server %s, want %s", got, want)
+	}
+}